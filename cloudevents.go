@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/blendle/pg2kafka/config"
+	"github.com/blendle/pg2kafka/eventqueue"
+	"github.com/blendle/pg2kafka/sink"
+)
+
+const (
+	cloudEventsSpecVersion = "1.0"
+
+	// cloudEventsContentType is the content type of CloudEvents in
+	// structured mode.
+	cloudEventsContentType = "application/cloudevents+json"
+)
+
+// cloudEvent holds the CloudEvents attributes of an event, as described by
+// https://github.com/cloudevents/spec/blob/v1.0.2/cloudevents/spec.md.
+type cloudEvent struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Subject         string          `json:"subject,omitempty"`
+	Time            string          `json:"time"`
+	DataContentType string          `json:"datacontenttype"`
+	Data            json.RawMessage `json:"data,omitempty"`
+}
+
+// newCloudEvent returns the CloudEvent of an event, with the given data. Its
+// source is the table the event belongs to, such as `/db/public/users`, and
+// its type is derived from the statement, such as `pg2kafka.update`.
+func newCloudEvent(event *eventqueue.Event, data json.RawMessage) *cloudEvent {
+	source := []string{"", databaseName}
+	if event.Schema != "" {
+		source = append(source, event.Schema)
+	}
+	source = append(source, event.TableName)
+
+	return &cloudEvent{
+		SpecVersion:     cloudEventsSpecVersion,
+		ID:              event.UUID,
+		Source:          strings.Join(source, "/"),
+		Type:            "pg2kafka." + strings.ToLower(event.Statement),
+		Subject:         string(event.ExternalID),
+		Time:            event.CreatedAt.UTC().Format(time.RFC3339Nano),
+		DataContentType: "application/json",
+		Data:            data,
+	}
+}
+
+// encodeCloudEvent encodes an event as a CloudEvent in structured mode,
+// returning the message value, or in binary mode, returning the data as
+// message value and the attributes as headers.
+func encodeCloudEvent(event *eventqueue.Event, table *config.TableConfig) ([]byte, []sink.Header, error) {
+	data, err := transformData(event, table)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ce := newCloudEvent(event, data)
+	if table.Format == config.FormatCloudEvents {
+		value, err := json.Marshal(ce)
+		return value, nil, err
+	}
+
+	return data, ce.headers(), nil
+}
+
+// headers returns the attributes as headers, following the Kafka protocol
+// binding, without the data content type which is set as `content-type`.
+func (ce *cloudEvent) headers() []sink.Header {
+	headers := []sink.Header{
+		{Key: "ce_specversion", Value: []byte(ce.SpecVersion)},
+		{Key: "ce_id", Value: []byte(ce.ID)},
+		{Key: "ce_source", Value: []byte(ce.Source)},
+		{Key: "ce_type", Value: []byte(ce.Type)},
+		{Key: "ce_time", Value: []byte(ce.Time)},
+	}
+	if ce.Subject != "" {
+		headers = append(headers, sink.Header{Key: "ce_subject", Value: []byte(ce.Subject)})
+	}
+
+	return headers
+}
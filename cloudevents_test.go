@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/blendle/pg2kafka/config"
+	"github.com/blendle/pg2kafka/eventqueue"
+)
+
+func TestNewMessage_CloudEvents(t *testing.T) {
+	databaseName = "shop"
+	defer func() { databaseName = "" }()
+
+	table := &config.TableConfig{
+		Format:     config.FormatCloudEvents,
+		Headers:    true,
+		Transforms: config.Transforms{Exclude: []string{"price"}},
+	}
+
+	event := &eventqueue.Event{
+		UUID:       "d6521ce5-4068-45e4-a9ad-c0949033a55b",
+		ExternalID: []byte("CM01-R"),
+		TableName:  "products",
+		Schema:     "public",
+		Statement:  "UPDATE",
+		Data:       []byte(`{"sku":"CM01-R","price":1299}`),
+		CreatedAt:  time.Date(2018, 1, 2, 3, 4, 5, 0, time.FixedZone("CET", 3600)),
+	}
+
+	msg, err := newMessage(event, table)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ce := map[string]interface{}{}
+	if err = json.Unmarshal(msg.Value, &ce); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]interface{}{
+		"specversion":     "1.0",
+		"id":              "d6521ce5-4068-45e4-a9ad-c0949033a55b",
+		"source":          "/shop/public/products",
+		"type":            "pg2kafka.update",
+		"subject":         "CM01-R",
+		"time":            "2018-01-02T02:04:05Z",
+		"datacontenttype": "application/json",
+		"data":            map[string]interface{}{"sku": "CM01-R"},
+	}
+	if len(ce) != len(expected) {
+		t.Errorf("Expected attributes %v, got %v", expected, ce)
+	}
+	for k, v := range expected {
+		actual, _ := json.Marshal(ce[k])
+		want, _ := json.Marshal(v)
+		if string(actual) != string(want) {
+			t.Errorf("Expected %s to be %s, got %s", k, want, actual)
+		}
+	}
+
+	if msg.Headers[0].Key != "content-type" || string(msg.Headers[0].Value) != cloudEventsContentType {
+		t.Errorf("Expected cloudevents content-type, got %s=%s", msg.Headers[0].Key, msg.Headers[0].Value)
+	}
+}
+
+func TestNewMessage_CloudEventsBinary(t *testing.T) {
+	databaseName = "shop"
+	defer func() { databaseName = "" }()
+
+	event := &eventqueue.Event{
+		UUID:      "d6521ce5-4068-45e4-a9ad-c0949033a55b",
+		TableName: "products",
+		Statement: "UPDATE",
+		Data:      []byte(`{"sku":"CM01-R","price":1299}`),
+		CreatedAt: time.Date(2018, 1, 2, 3, 4, 5, 0, time.FixedZone("CET", 3600)),
+	}
+
+	msg, err := newMessage(event, &config.TableConfig{Format: config.FormatCloudEventsBinary})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(msg.Value) != string(event.Data) {
+		t.Errorf("Expected data as value, got %s", msg.Value)
+	}
+
+	expected := map[string]string{
+		"content-type":   "application/json",
+		"ce_specversion": "1.0",
+		"ce_id":          "d6521ce5-4068-45e4-a9ad-c0949033a55b",
+		"ce_source":      "/shop/products",
+		"ce_type":        "pg2kafka.update",
+		"ce_time":        "2018-01-02T02:04:05Z",
+	}
+	if len(msg.Headers) != len(expected) {
+		t.Errorf("Expected %d headers, got %v", len(expected), msg.Headers)
+	}
+	for _, h := range msg.Headers {
+		if v := expected[h.Key]; v != string(h.Value) {
+			t.Errorf("Expected header %q to be %q, got %q", h.Key, v, h.Value)
+		}
+	}
+}
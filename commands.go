@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/blendle/pg2kafka/config"
+	"github.com/blendle/pg2kafka/eventqueue"
+	"github.com/pkg/errors"
+)
+
+// command is a subcommand of the pg2kafka binary.
+type command struct {
+	name        string
+	args        string
+	description string
+	nargs       int
+
+	// sink is true for commands that publish events, and therefore need the
+	// full configuration instead of just the database.
+	sink bool
+
+	// direct is true for commands that need a direct connection to the
+	// database, bypassing poolers, see config.Config.DirectURL.
+	direct bool
+
+	// flags defines the flags of the command, if it has any.
+	flags func(fs *flag.FlagSet)
+
+	run func(ctx context.Context, cfg *config.Config, eq *eventqueue.Queue, args []string) error
+}
+
+// commands are the subcommands operating on the database, run is handled
+// separately.
+var commands = []*command{
+	{
+		name:        "migrate",
+		description: "Create or update the pg2kafka schema, functions and triggers.",
+		direct:      true,
+		run: func(ctx context.Context, cfg *config.Config, eq *eventqueue.Queue, args []string) error {
+			migrations, err := eq.Migrate(ctx)
+			for _, m := range migrations {
+				fmt.Printf("Applied migration %d_%s\n", m.Version, m.Name)
+			}
+			if err == nil && len(migrations) == 0 {
+				fmt.Println("The pg2kafka schema is up to date")
+			}
+
+			return errors.Wrap(err, "error migrating the pg2kafka schema")
+		},
+	},
+	{
+		name:        "setup",
+		args:        "<table> <external-id-column>",
+		description: "Start tracking changes to a table, and snapshot its current rows.",
+		nargs:       2,
+		run: func(ctx context.Context, cfg *config.Config, eq *eventqueue.Queue, args []string) error {
+			return eq.SetupTable(ctx, args[0], args[1])
+		},
+	},
+	{
+		name:        "teardown",
+		args:        "<table>",
+		description: "Stop tracking changes to a table.",
+		nargs:       1,
+		run: func(ctx context.Context, cfg *config.Config, eq *eventqueue.Queue, args []string) error {
+			return eq.TeardownTable(ctx, args[0])
+		},
+	},
+	{
+		name:        "snapshot",
+		args:        "<table>",
+		description: "Enqueue the current rows of a tracked table as snapshot events.",
+		nargs:       1,
+		run: func(ctx context.Context, cfg *config.Config, eq *eventqueue.Queue, args []string) error {
+			return eq.SnapshotTable(ctx, args[0])
+		},
+	},
+	{
+		name:        "status",
+		description: "Show the tracked tables, their triggers and unprocessed events.",
+		run: func(ctx context.Context, cfg *config.Config, eq *eventqueue.Queue, args []string) error {
+			statuses, err := eq.TableStatuses(ctx)
+			if err != nil {
+				return errors.Wrap(err, "error fetching status")
+			}
+
+			return printStatus(os.Stdout, statuses)
+		},
+	},
+	verifyCommand(),
+	replayCommand(),
+}
+
+func verifyCommand() *command {
+	repair := false
+
+	return &command{
+		name:        "verify",
+		args:        "[-repair]",
+		description: "Check the tracked tables for missing triggers and external ID columns.",
+		flags: func(fs *flag.FlagSet) {
+			fs.BoolVar(&repair, "repair", false, "reinstall missing triggers")
+		},
+		run: func(ctx context.Context, cfg *config.Config, eq *eventqueue.Queue, args []string) error {
+			drift, err := eq.Verify(ctx)
+			if err != nil {
+				return errors.Wrap(err, "error verifying tables")
+			}
+
+			if repair {
+				repaired, rerr := eq.Repair(ctx, drift)
+				for _, d := range repaired {
+					fmt.Printf("Reinstalled the trigger of %s, snapshot it to recover changes made without it\n", d.Table)
+				}
+				if rerr != nil {
+					return rerr
+				}
+
+				if drift, err = eq.Verify(ctx); err != nil {
+					return errors.Wrap(err, "error verifying tables")
+				}
+			}
+
+			for _, d := range drift {
+				fmt.Println(d)
+			}
+			if len(drift) > 0 {
+				return errors.Errorf("found %d problems with tracked tables", len(drift))
+			}
+
+			fmt.Println("All tracked tables are set up correctly")
+			return nil
+		},
+	}
+}
+
+func replayCommand() *command {
+	opts := eventqueue.ReplayOptions{}
+	var topic, since, until string
+
+	return &command{
+		name:        "replay",
+		args:        "-table <table> [flags]",
+		description: "Publish processed events of a table again, without marking them unprocessed.",
+		sink:        true,
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&opts.Table, "table", "", "table whose events are replayed (required)")
+			fs.IntVar(&opts.FromID, "from-id", 0, "first event id to replay")
+			fs.IntVar(&opts.ToID, "to-id", 0, "last event id to replay")
+			fs.StringVar(&since, "since", "", "replay events created at or after this RFC 3339 time")
+			fs.StringVar(&until, "until", "", "replay events created before this RFC 3339 time")
+			fs.IntVar(&opts.Rate, "rate", 100, "maximum number of events per second, 0 for unlimited")
+			fs.StringVar(&topic, "topic", "", "publish to this topic instead of the table's own")
+		},
+		run: func(ctx context.Context, cfg *config.Config, eq *eventqueue.Queue, args []string) error {
+			var err error
+			if opts.Since, err = parseTime(since); err != nil {
+				return errors.Wrap(err, "invalid -since")
+			}
+			if opts.Until, err = parseTime(until); err != nil {
+				return errors.Wrap(err, "invalid -until")
+			}
+
+			configurePublishing(cfg, eq)
+			s, err := setupSink(cfg.Sink, cfg.Tables)
+			if err != nil {
+				return err
+			}
+			defer s.Close() // nolint: errcheck
+
+			count, err := replayEvents(ctx, s, eq, opts, topic)
+			fmt.Printf("Replayed %d events\n", count)
+			return err
+		},
+	}
+}
+
+// parseTime parses an optional RFC 3339 time.
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+
+	return time.Parse(time.RFC3339, s)
+}
+
+// execute runs the subcommand named by the first argument. Without arguments
+// pg2kafka runs the service, as it did before it had subcommands.
+func execute(args []string) error {
+	if len(args) == 0 || args[0] == "run" {
+		return run()
+	}
+
+	if args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		usage(os.Stdout)
+		return nil
+	}
+
+	cmd := findCommand(args[0])
+	if cmd == nil {
+		usage(os.Stderr)
+		return errors.Errorf("unknown command %q", args[0])
+	}
+
+	flags := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: pg2kafka %s %s\n\n%s\n", cmd.name, cmd.args, cmd.description)
+		flags.PrintDefaults()
+	}
+	if cmd.flags != nil {
+		cmd.flags(flags)
+	}
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+	if flags.NArg() != cmd.nargs {
+		flags.Usage()
+		return errors.Errorf("%s expects %d arguments, got %d", cmd.name, cmd.nargs, flags.NArg())
+	}
+
+	load := config.LoadDatabase
+	if cmd.sink {
+		load = config.Load
+	}
+	cfg, err := load(os.Getenv("CONFIG_FILE"))
+	if err != nil {
+		return err
+	}
+
+	conninfo := cfg.DatabaseURL
+	if cmd.direct {
+		conninfo = cfg.DirectURL()
+	}
+
+	eq, err := eventqueue.New(conninfo)
+	if err != nil {
+		return errors.Wrap(err, "error opening db connection")
+	}
+	eq.SetQueryTimeout(cfg.QueryTimeout)
+	defer eq.Close() // nolint: errcheck
+
+	return cmd.run(context.Background(), cfg, eq, flags.Args())
+}
+
+func findCommand(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+
+	return nil
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "usage: pg2kafka [command] [arguments]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "commands:")
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "  run\tPublish events until stopped (default).\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(tw, "  %s %s\t%s\n", cmd.name, cmd.args, cmd.description)
+	}
+	tw.Flush() // nolint: errcheck
+}
+
+func printStatus(w io.Writer, statuses []*eventqueue.TableStatus) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TABLE\tEXTERNAL ID\tTRACKED\tTRIGGER\tUNPROCESSED\tOLDEST")
+	for _, s := range statuses {
+		oldest := "-"
+		if s.Backlog.Count > 0 {
+			oldest = s.Backlog.OldestAge.Truncate(time.Second).String()
+		}
+
+		fmt.Fprintf(
+			tw, "%s\t%s\t%s\t%s\t%d\t%s\n",
+			s.Table, s.ExternalID, yesNo(s.Tracked), yesNo(s.Trigger), s.Backlog.Count, oldest,
+		)
+	}
+
+	return tw.Flush()
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+
+	return "no"
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/blendle/pg2kafka/eventqueue"
+)
+
+var executeErrorTests = []struct {
+	args []string
+	err  string
+}{
+	{[]string{"frobnicate"}, `unknown command "frobnicate"`},
+	{[]string{"setup", "users"}, "setup expects 2 arguments, got 1"},
+	{[]string{"teardown"}, "teardown expects 1 arguments, got 0"},
+	{[]string{"status", "users"}, "status expects 0 arguments, got 1"},
+	{[]string{"verify", "-repair", "users"}, "verify expects 0 arguments, got 1"},
+}
+
+func TestExecute_Errors(t *testing.T) {
+	for _, tt := range executeErrorTests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			err := execute(tt.args)
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("Expected error %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestPrintStatus(t *testing.T) {
+	statuses := []*eventqueue.TableStatus{
+		{
+			Table:      "products",
+			ExternalID: "sku",
+			Tracked:    true,
+			Trigger:    true,
+			Backlog:    eventqueue.Backlog{Count: 3, OldestAge: 90*time.Second + time.Millisecond},
+		},
+		{
+			Table:   "users",
+			Backlog: eventqueue.Backlog{},
+		},
+	}
+
+	buf := &bytes.Buffer{}
+	if err := printStatus(buf, statuses); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "" +
+		"TABLE     EXTERNAL ID  TRACKED  TRIGGER  UNPROCESSED  OLDEST\n" +
+		"products  sku          yes      yes      3            1m30s\n" +
+		"users                  no       no       0            -\n"
+
+	if buf.String() != expected {
+		t.Errorf("Unexpected output:\n%s\nwant:\n%s", buf.String(), expected)
+	}
+}
+
+var parseTimeTests = []struct {
+	in  string
+	out time.Time
+	err bool
+}{
+	{"", time.Time{}, false},
+	{"2017-11-02T16:14:36Z", time.Date(2017, 11, 2, 16, 14, 36, 0, time.UTC), false},
+	{"yesterday", time.Time{}, true},
+}
+
+func TestParseTime(t *testing.T) {
+	for _, tt := range parseTimeTests {
+		t.Run(tt.in, func(t *testing.T) {
+			actual, err := parseTime(tt.in)
+			if (err != nil) != tt.err {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if !actual.Equal(tt.out) {
+				t.Errorf("parseTime(%q) => %v, want: %v", tt.in, actual, tt.out)
+			}
+		})
+	}
+}
@@ -0,0 +1,780 @@
+// Package config loads the configuration of pg2kafka from a YAML file and
+// environment variables.
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Sink types.
+const (
+	SinkKafka   = "kafka"
+	SinkStdout  = "stdout"
+	SinkFile    = "file"
+	SinkWebhook = "webhook"
+	SinkNATS    = "nats"
+	SinkRedis   = "redis"
+)
+
+// Formats events can be encoded in.
+const (
+	FormatJSON     = "json"
+	FormatProtobuf = "protobuf"
+
+	// FormatCloudEvents encodes events as CloudEvents in structured mode, and
+	// FormatCloudEventsBinary in binary mode, with the attributes as headers.
+	FormatCloudEvents       = "cloudevents"
+	FormatCloudEventsBinary = "cloudevents-binary"
+)
+
+// AllTables is the name of the table section that applies to every table
+// without a section of its own.
+const AllTables = "*"
+
+// Config is the configuration of pg2kafka.
+type Config struct {
+	DatabaseURL       string        `yaml:"database_url"`
+	DirectDatabaseURL string        `yaml:"direct_database_url"`
+	PerformMigrations bool          `yaml:"perform_migrations"`
+	TopicNamespace    string        `yaml:"topic_namespace"`
+	QueryTimeout      time.Duration `yaml:"query_timeout"`
+	ShutdownTimeout   time.Duration `yaml:"shutdown_timeout"`
+	HTTPAddr          string        `yaml:"http_addr"`
+
+	// PollInterval is the time after which the queue is processed without
+	// being notified of new events, in case notifications were missed, 1
+	// minute by default.
+	PollInterval time.Duration `yaml:"poll_interval"`
+
+	// PollOnly processes the queue by polling alone, without listening for
+	// notifications, for databases behind a pooler such as PgBouncer in
+	// transaction pooling mode. The queue is polled every MinPollInterval,
+	// 100ms by default, while there are events, backing off up to
+	// PollInterval while there are none.
+	PollOnly        bool          `yaml:"poll_only"`
+	MinPollInterval time.Duration `yaml:"min_poll_interval"`
+
+	// BatchSize is the maximum number of events fetched at once, 1000 by
+	// default, and MaxBatchBytes limits the combined size of their data. The
+	// first event of a batch is always fetched, however large. The size is not
+	// limited when MaxBatchBytes is zero.
+	BatchSize     int `yaml:"batch_size"`
+	MaxBatchBytes int `yaml:"max_batch_bytes"`
+
+	// SchemaCacheTTL is how long the schemas of tables are cached, 1 minute by
+	// default, see eventqueue.SchemaCache.
+	SchemaCacheTTL time.Duration `yaml:"schema_cache_ttl"`
+
+	// Coalesce merges the events of the same row fetched together, so only
+	// its latest state is published, see eventqueue.Coalesce.
+	Coalesce bool `yaml:"coalesce"`
+
+	Health        HealthConfig        `yaml:"health"`
+	Drift         DriftConfig         `yaml:"drift"`
+	SchemaChanges SchemaChangesConfig `yaml:"schema_changes"`
+	Protobuf      ProtobufConfig      `yaml:"protobuf"`
+	Sink          SinkConfig          `yaml:"sink"`
+	Tables        Tables              `yaml:"tables"`
+}
+
+// HealthConfig configures the health endpoints.
+type HealthConfig struct {
+	// MaxBacklogAge makes the liveness check fail when the oldest unprocessed
+	// event is older than this. It is disabled when zero.
+	MaxBacklogAge time.Duration `yaml:"max_backlog_age"`
+}
+
+// DriftConfig configures the periodic check for tracked tables whose trigger
+// or external ID column went missing.
+type DriftConfig struct {
+	// Interval is the time between checks, 5 minutes by default.
+	Interval time.Duration `yaml:"interval"`
+
+	// Repair reinstalls missing triggers when they are found.
+	Repair bool `yaml:"repair"`
+}
+
+// SchemaChangesConfig configures the events recording schema changes to
+// tracked tables.
+type SchemaChangesConfig struct {
+	// Enabled installs the event trigger recording schema changes, which
+	// requires a superuser.
+	Enabled bool `yaml:"enabled"`
+
+	// Topic overrides the default `pg2kafka.$namespace.$database.schema_changes`.
+	Topic string `yaml:"topic"`
+}
+
+// ProtobufConfig configures the protobuf format.
+type ProtobufConfig struct {
+	// DescriptorSetFile is where the descriptors of the published messages are
+	// written to, as a FileDescriptorSet.
+	DescriptorSetFile string `yaml:"descriptor_set_file"`
+}
+
+// SinkConfig configures where events are delivered.
+type SinkConfig struct {
+	Type    string        `yaml:"type"`
+	Kafka   KafkaConfig   `yaml:"kafka"`
+	File    FileConfig    `yaml:"file"`
+	Webhook WebhookConfig `yaml:"webhook"`
+	NATS    NATSConfig    `yaml:"nats"`
+	Redis   RedisConfig   `yaml:"redis"`
+}
+
+// KafkaConfig configures the Kafka sink.
+type KafkaConfig struct {
+	Broker string `yaml:"broker"`
+
+	// Properties are passed to librdkafka as is, and take precedence over the
+	// properties pg2kafka sets itself. See
+	// https://github.com/edenhill/librdkafka/blob/master/CONFIGURATION.md
+	Properties map[string]string `yaml:"properties"`
+
+	// PropertyFiles maps librdkafka properties to files their value is read
+	// from, so secrets such as sasl.password do not have to be part of the
+	// configuration. The values are added to Properties when loading.
+	PropertyFiles map[string]string `yaml:"property_files"`
+}
+
+// kafkaEnv are the environment variables starting with KAFKA_ that are not
+// librdkafka properties.
+var kafkaEnv = map[string]bool{
+	"KAFKA_BROKER":  true,
+	"KAFKA_HEADERS": true,
+}
+
+// FileConfig configures the file sink.
+type FileConfig struct {
+	Path string `yaml:"path"`
+}
+
+// WebhookConfig configures the webhook sink. URLs for specific tables are
+// configured in their table section.
+type WebhookConfig struct {
+	URL    string `yaml:"url"`
+	Secret string `yaml:"secret"`
+
+	// MaxRetries is 3 when not set, and can be set to 0 to disable retries.
+	MaxRetries *int          `yaml:"max_retries"`
+	Backoff    time.Duration `yaml:"backoff"`
+}
+
+// NATSConfig configures the NATS sink.
+type NATSConfig struct {
+	URL string `yaml:"url"`
+}
+
+// RedisConfig configures the Redis sink.
+type RedisConfig struct {
+	URL    string `yaml:"url"`
+	MaxLen int64  `yaml:"max_len"`
+}
+
+// Tables holds the configuration of tables by name.
+type Tables map[string]*TableConfig
+
+// TableConfig configures how the events of a table are published.
+type TableConfig struct {
+	// Topic overrides the default `pg2kafka.$namespace.$database.$table`.
+	Topic string `yaml:"topic"`
+
+	// Format is the encoding of the events, json (default), protobuf,
+	// cloudevents or cloudevents-binary.
+	Format string `yaml:"format"`
+
+	// Key is the column used as the message key, instead of the external ID.
+	// Events that do not contain the column, such as updates that did not
+	// change it, fall back to the external ID.
+	Key string `yaml:"key"`
+
+	// Headers adds event metadata to the message headers.
+	Headers bool `yaml:"headers"`
+
+	// Schema adds the names, types and nullability of the table's columns to
+	// the events.
+	Schema bool `yaml:"schema"`
+
+	// LosslessNumbers encodes numeric and bigint columns as JSON strings, as
+	// many JSON parsers turn numbers into floats, losing precision.
+	LosslessNumbers bool `yaml:"lossless_numbers"`
+
+	// WebhookURL overrides the webhook URL for this table.
+	WebhookURL string `yaml:"webhook_url"`
+
+	Transforms Transforms `yaml:"transforms"`
+	Filters    Filters    `yaml:"filters"`
+}
+
+// Get returns the configuration of the given table, falling back to the
+// AllTables section, and to an empty configuration if neither exists. Table
+// sections inherit the settings they do not set from the AllTables section
+// when loading.
+func (t Tables) Get(table string) *TableConfig {
+	if c, ok := t[table]; ok {
+		return c
+	}
+	if c, ok := t[AllTables]; ok {
+		return c
+	}
+
+	return &TableConfig{}
+}
+
+// Load loads the configuration from the YAML file at path, if it is not empty,
+// overrides it with any environment variables that are set, and validates the
+// result.
+func Load(path string) (*Config, error) {
+	return load(path, os.Environ())
+}
+
+// LoadDatabase is like Load, but only validates the settings needed to
+// connect to the database, for commands that do not publish events.
+func LoadDatabase(path string) (*Config, error) {
+	c, err := read(path, os.Environ())
+	if err != nil {
+		return nil, err
+	}
+
+	if problems := c.validateDatabase(); len(problems) > 0 {
+		return nil, invalid(problems)
+	}
+
+	return c, nil
+}
+
+// DirectURL returns the URL connecting to the database directly, bypassing a
+// pooler such as PgBouncer, for the listener and other features relying on
+// sessions. It falls back to DatabaseURL when DirectDatabaseURL is not set.
+func (c *Config) DirectURL() string {
+	if c.DirectDatabaseURL != "" {
+		return c.DirectDatabaseURL
+	}
+
+	return c.DatabaseURL
+}
+
+func load(path string, environ []string) (*Config, error) {
+	c, err := read(path, environ)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = c.Validate(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// read reads the configuration file and environment, without validating the
+// result.
+func read(path string, environ []string) (*Config, error) {
+	env := map[string]string{}
+	for _, kv := range environ {
+		if i := strings.Index(kv, "="); i > 0 {
+			env[kv[:i]] = kv[i+1:]
+		}
+	}
+
+	c := &Config{}
+	if path != "" {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, errors.Wrap(err, "error reading config file")
+		}
+
+		if err = yaml.UnmarshalStrict(b, c); err != nil {
+			return nil, errors.Wrapf(err, "error parsing config file %s", path)
+		}
+	}
+
+	if err := c.applyEnv(env); err != nil {
+		return nil, err
+	}
+
+	if err := c.Sink.Kafka.readPropertyFiles(); err != nil {
+		return nil, err
+	}
+
+	c.Tables.inheritAllTables()
+	c.applyDefaults()
+	return c, nil
+}
+
+// applyEnv overrides the configuration with the environment variables that
+// pg2kafka was configured through before it had a configuration file, and
+// with the librdkafka properties set through KAFKA_ prefixed variables.
+func (c *Config) applyEnv(env map[string]string) error {
+	getenv := func(key string) string {
+		return env[key]
+	}
+	setString := func(dst *string, key string) {
+		if v := getenv(key); v != "" {
+			*dst = v
+		}
+	}
+	var err error
+	setDuration := func(dst *time.Duration, key string) {
+		if v := getenv(key); v != "" && err == nil {
+			*dst, err = time.ParseDuration(v)
+			err = errors.Wrapf(err, "invalid %s", key)
+		}
+	}
+
+	setString(&c.DatabaseURL, "DATABASE_URL")
+	setString(&c.DirectDatabaseURL, "DIRECT_DATABASE_URL")
+	setString(&c.TopicNamespace, "TOPIC_NAMESPACE")
+	setString(&c.HTTPAddr, "HTTP_ADDR")
+	setString(&c.SchemaChanges.Topic, "SCHEMA_CHANGES_TOPIC")
+	setString(&c.Protobuf.DescriptorSetFile, "PROTOBUF_DESCRIPTOR_SET_FILE")
+	setString(&c.Sink.Type, "SINK")
+	setString(&c.Sink.Kafka.Broker, "KAFKA_BROKER")
+	setString(&c.Sink.File.Path, "SINK_FILE")
+	setString(&c.Sink.Webhook.URL, "WEBHOOK_URL")
+	setString(&c.Sink.Webhook.Secret, "WEBHOOK_SECRET")
+	setString(&c.Sink.NATS.URL, "NATS_URL")
+	setString(&c.Sink.Redis.URL, "REDIS_URL")
+	setDuration(&c.QueryTimeout, "QUERY_TIMEOUT")
+	setDuration(&c.ShutdownTimeout, "SHUTDOWN_TIMEOUT")
+	setDuration(&c.PollInterval, "POLL_INTERVAL")
+	setDuration(&c.MinPollInterval, "MIN_POLL_INTERVAL")
+	setDuration(&c.Health.MaxBacklogAge, "HEALTH_MAX_BACKLOG_AGE")
+	setDuration(&c.Drift.Interval, "DRIFT_CHECK_INTERVAL")
+	setDuration(&c.SchemaCacheTTL, "SCHEMA_CACHE_TTL")
+	if err != nil {
+		return err
+	}
+
+	if v := getenv("PERFORM_MIGRATIONS"); v != "" {
+		c.PerformMigrations = v == "true"
+	}
+	if v := getenv("SCHEMA_CHANGES"); v != "" {
+		c.SchemaChanges.Enabled = v == "true"
+	}
+	if v := getenv("POLL_ONLY"); v != "" {
+		c.PollOnly = v == "true"
+	}
+	if v := getenv("COALESCE_EVENTS"); v != "" {
+		c.Coalesce = v == "true"
+	}
+	if v := getenv("DRIFT_REPAIR"); v != "" {
+		c.Drift.Repair = v == "true"
+	}
+	if getenv("DRY_RUN") != "" {
+		c.Sink.Type = SinkStdout
+	}
+	if v := getenv("WEBHOOK_MAX_RETRIES"); v != "" {
+		var retries int
+		if retries, err = strconv.Atoi(v); err != nil {
+			return errors.Wrap(err, "invalid WEBHOOK_MAX_RETRIES")
+		}
+		c.Sink.Webhook.MaxRetries = &retries
+	}
+	if v := getenv("BATCH_SIZE"); v != "" {
+		if c.BatchSize, err = strconv.Atoi(v); err != nil {
+			return errors.Wrap(err, "invalid BATCH_SIZE")
+		}
+	}
+	if v := getenv("MAX_BATCH_BYTES"); v != "" {
+		if c.MaxBatchBytes, err = strconv.Atoi(v); err != nil {
+			return errors.Wrap(err, "invalid MAX_BATCH_BYTES")
+		}
+	}
+	if v := getenv("REDIS_MAXLEN"); v != "" {
+		if c.Sink.Redis.MaxLen, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return errors.Wrap(err, "invalid REDIS_MAXLEN")
+		}
+	}
+
+	if c.Tables == nil {
+		c.Tables = Tables{}
+	}
+	for _, table := range parseList(getenv("KAFKA_HEADERS")) {
+		c.Tables.section(table).Headers = true
+	}
+	for _, table := range parseList(getenv("EVENT_SCHEMA")) {
+		c.Tables.section(table).Schema = true
+	}
+	for _, table := range parseList(getenv("LOSSLESS_NUMBERS")) {
+		c.Tables.section(table).LosslessNumbers = true
+	}
+	for table, format := range parsePairs(getenv("TABLE_FORMATS")) {
+		c.Tables.section(table).Format = format
+	}
+	for table, url := range parsePairs(getenv("WEBHOOK_TABLE_URLS")) {
+		c.Tables.section(table).WebhookURL = url
+	}
+
+	for key, value := range env {
+		if !strings.HasPrefix(key, "KAFKA_") || kafkaEnv[key] || isServiceLink(key) || value == "" {
+			continue
+		}
+		if strings.HasSuffix(key, "_FILE") {
+			property := kafkaProperty(strings.TrimSuffix(key, "_FILE"))
+			c.Sink.Kafka.PropertyFiles = setProperty(c.Sink.Kafka.PropertyFiles, property, value)
+			continue
+		}
+		c.Sink.Kafka.Properties = setProperty(c.Sink.Kafka.Properties, kafkaProperty(key), value)
+	}
+
+	return nil
+}
+
+// isServiceLink returns whether an environment variable is one of those
+// Kubernetes sets for a service named kafka, such as KAFKA_PORT or
+// KAFKA_SERVICE_HOST, which are not librdkafka properties.
+func isServiceLink(key string) bool {
+	return strings.HasPrefix(key, "KAFKA_PORT") || strings.HasPrefix(key, "KAFKA_SERVICE_")
+}
+
+// kafkaProperty converts an environment variable such as KAFKA_SASL_USERNAME
+// to the librdkafka property it sets, sasl.username.
+func kafkaProperty(key string) string {
+	key = strings.TrimPrefix(key, "KAFKA_")
+	return strings.Replace(strings.ToLower(key), "_", ".", -1)
+}
+
+func setProperty(properties map[string]string, key, value string) map[string]string {
+	if properties == nil {
+		properties = map[string]string{}
+	}
+	properties[key] = value
+
+	return properties
+}
+
+// readPropertyFiles adds the values of the property files to the properties.
+// Trailing newlines are removed, as most tools add them when writing secrets.
+func (k *KafkaConfig) readPropertyFiles() error {
+	for property, path := range k.PropertyFiles {
+		if _, ok := k.Properties[property]; ok {
+			return errors.Errorf("kafka property %s is set both directly and from a file", property)
+		}
+
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return errors.Wrapf(err, "error reading kafka property %s", property)
+		}
+
+		k.Properties = setProperty(k.Properties, property, strings.TrimRight(string(b), "\r\n"))
+	}
+
+	return nil
+}
+
+func (c *Config) applyDefaults() {
+	if c.Sink.Type == "" {
+		c.Sink.Type = SinkKafka
+	}
+	if c.HTTPAddr == "" {
+		c.HTTPAddr = ":8080"
+	}
+	if c.QueryTimeout == 0 {
+		c.QueryTimeout = 30 * time.Second
+	}
+	if c.PollInterval == 0 {
+		c.PollInterval = time.Minute
+	}
+	if c.MinPollInterval == 0 {
+		c.MinPollInterval = 100 * time.Millisecond
+	}
+	if c.SchemaCacheTTL == 0 {
+		c.SchemaCacheTTL = time.Minute
+	}
+	if c.BatchSize == 0 {
+		c.BatchSize = 1000
+	}
+	if c.ShutdownTimeout == 0 {
+		c.ShutdownTimeout = 30 * time.Second
+	}
+	if c.Drift.Interval == 0 {
+		c.Drift.Interval = 5 * time.Minute
+	}
+	if c.Sink.Webhook.MaxRetries == nil {
+		retries := 3
+		c.Sink.Webhook.MaxRetries = &retries
+	}
+	if c.Sink.Webhook.Backoff == 0 {
+		c.Sink.Webhook.Backoff = time.Second
+	}
+	for _, t := range c.Tables {
+		if t.Format == "" {
+			t.Format = FormatJSON
+		}
+	}
+}
+
+// Validate checks the configuration, and returns an error describing every
+// problem it found.
+func (c *Config) Validate() error {
+	problems := c.validateDatabase()
+	addf := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if c.ShutdownTimeout < 0 || c.PollInterval < 0 || c.SchemaCacheTTL < 0 ||
+		c.Health.MaxBacklogAge < 0 || c.Drift.Interval < 0 {
+		addf("durations can not be negative")
+	}
+	if c.PollOnly && (c.MinPollInterval <= 0 || c.PollInterval < c.MinPollInterval) {
+		addf("poll_interval (POLL_INTERVAL) can not be shorter than min_poll_interval (MIN_POLL_INTERVAL)")
+	}
+	if c.BatchSize < 0 || c.MaxBatchBytes < 0 {
+		addf("batch_size (BATCH_SIZE) and max_batch_bytes (MAX_BATCH_BYTES) can not be negative")
+	}
+
+	switch c.Sink.Type {
+	case SinkKafka:
+		if c.Sink.Kafka.Broker == "" && c.Sink.Kafka.Properties["bootstrap.servers"] == "" {
+			addf("sink.kafka.broker (KAFKA_BROKER) is required for the kafka sink")
+		}
+		for _, p := range c.Sink.Kafka.validate() {
+			addf("sink.kafka.properties: %s", p)
+		}
+	case SinkFile:
+		if c.Sink.File.Path == "" {
+			addf("sink.file.path (SINK_FILE) is required for the file sink")
+		}
+	case SinkWebhook:
+		if c.Sink.Webhook.URL == "" && !c.Tables.hasWebhookURL() {
+			addf("sink.webhook.url (WEBHOOK_URL) or a webhook_url per table is required for the webhook sink")
+		}
+		if c.Sink.Webhook.MaxRetries != nil && *c.Sink.Webhook.MaxRetries < 0 {
+			addf("sink.webhook.max_retries can not be negative")
+		}
+	case SinkRedis:
+		if c.Sink.Redis.URL == "" {
+			addf("sink.redis.url (REDIS_URL) is required for the redis sink")
+		}
+	case SinkStdout, SinkNATS:
+	default:
+		addf("sink.type (SINK) %q is unknown, expected one of kafka, stdout, file, webhook, nats, redis", c.Sink.Type)
+	}
+
+	for _, name := range c.Tables.names() {
+		for _, p := range c.Tables[name].validate() {
+			addf("tables.%s.%s", name, p)
+		}
+		// The webhook sink posts values as JSON, which protobuf isn't, and
+		// drops headers, which binary CloudEvents keep their attributes in.
+		format := c.Tables[name].Format
+		if c.Sink.Type == SinkWebhook && (format == FormatProtobuf || format == FormatCloudEventsBinary) {
+			addf("tables.%s.format: %s can not be used with the webhook sink", name, format)
+		}
+	}
+
+	if len(problems) > 0 {
+		return invalid(problems)
+	}
+
+	return nil
+}
+
+func (c *Config) validateDatabase() []string {
+	problems := []string{}
+	if c.DatabaseURL == "" {
+		problems = append(problems, "database_url (DATABASE_URL) is required")
+	}
+	if c.QueryTimeout < 0 {
+		problems = append(problems, "query_timeout (QUERY_TIMEOUT) can not be negative")
+	}
+
+	return problems
+}
+
+func invalid(problems []string) error {
+	return errors.New("invalid configuration:\n  " + strings.Join(problems, "\n  "))
+}
+
+// validate checks the librdkafka properties that are most often got wrong.
+// librdkafka itself rejects unknown properties and invalid values when the
+// producer is created, but does not check that certificates exist, or that
+// credentials are complete.
+func (k *KafkaConfig) validate() []string {
+	problems := []string{}
+	addf := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	keys := make([]string, 0, len(k.Properties))
+	for key := range k.Properties {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := k.Properties[key]
+		if key == "" || strings.ToLower(key) != key {
+			addf("invalid property name %q", key)
+		}
+		if strings.HasSuffix(key, ".location") && value != "probe" {
+			if _, err := os.Stat(value); err != nil {
+				addf("%s: %v", key, err)
+			}
+		}
+	}
+
+	protocol := strings.ToLower(k.Properties["security.protocol"])
+	switch protocol {
+	case "", "plaintext", "ssl", "sasl_plaintext", "sasl_ssl":
+	default:
+		addf("security.protocol: unknown protocol %q, expected one of plaintext, ssl, sasl_plaintext, sasl_ssl", protocol)
+	}
+
+	if strings.HasPrefix(protocol, "sasl_") {
+		mechanism := k.Properties["sasl.mechanisms"]
+		if m, ok := k.Properties["sasl.mechanism"]; ok {
+			mechanism = m
+		}
+
+		switch mechanism {
+		case "", "GSSAPI", "OAUTHBEARER":
+		case "PLAIN", "SCRAM-SHA-256", "SCRAM-SHA-512":
+			if k.Properties["sasl.username"] == "" || k.Properties["sasl.password"] == "" {
+				addf("sasl.username and sasl.password are required for sasl mechanism %s", mechanism)
+			}
+		default:
+			addf("sasl.mechanisms: unknown mechanism %q, expected one of GSSAPI, PLAIN, SCRAM-SHA-256, SCRAM-SHA-512, OAUTHBEARER", mechanism) // nolint: lll
+		}
+	}
+
+	return problems
+}
+
+func (t *TableConfig) validate() []string {
+	problems := []string{}
+	switch t.Format {
+	case FormatJSON, FormatProtobuf, FormatCloudEvents, FormatCloudEventsBinary:
+	default:
+		problems = append(problems, "format: unknown format "+strconv.Quote(t.Format))
+	}
+
+	for _, s := range t.Filters.Statements {
+		switch s {
+		case "SNAPSHOT", "INSERT", "UPDATE", "DELETE", "SCHEMA":
+		default:
+			problems = append(problems, "filters.statements: unknown statement "+strconv.Quote(s))
+		}
+	}
+
+	for from, to := range t.Transforms.Rename {
+		if to == "" {
+			problems = append(problems, "transforms.rename: empty name for column "+strconv.Quote(from))
+		}
+	}
+
+	return problems
+}
+
+// inheritAllTables fills in the settings the table sections do not set, such
+// as the sections created by environment variables, from the AllTables
+// section.
+func (t Tables) inheritAllTables() {
+	defaults, ok := t[AllTables]
+	if !ok {
+		return
+	}
+
+	for name, table := range t {
+		if name != AllTables {
+			table.inherit(defaults)
+		}
+	}
+}
+
+// inherit fills in the settings that are not set from the given section.
+// Booleans can not be told apart from being unset, so they are inherited when
+// either section enables them.
+func (t *TableConfig) inherit(defaults *TableConfig) {
+	if t.Topic == "" {
+		t.Topic = defaults.Topic
+	}
+	if t.Format == "" {
+		t.Format = defaults.Format
+	}
+	if t.Key == "" {
+		t.Key = defaults.Key
+	}
+	if t.WebhookURL == "" {
+		t.WebhookURL = defaults.WebhookURL
+	}
+	t.Headers = t.Headers || defaults.Headers
+	t.Schema = t.Schema || defaults.Schema
+	t.LosslessNumbers = t.LosslessNumbers || defaults.LosslessNumbers
+
+	if len(t.Transforms.Exclude) == 0 {
+		t.Transforms.Exclude = defaults.Transforms.Exclude
+	}
+	if len(t.Transforms.Rename) == 0 {
+		t.Transforms.Rename = defaults.Transforms.Rename
+	}
+	if len(t.Filters.Statements) == 0 {
+		t.Filters.Statements = defaults.Filters.Statements
+	}
+}
+
+// section returns the configuration of the given table, creating it if it
+// does not exist yet.
+func (t Tables) section(table string) *TableConfig {
+	if _, ok := t[table]; !ok {
+		t[table] = &TableConfig{}
+	}
+
+	return t[table]
+}
+
+func (t Tables) names() []string {
+	names := make([]string, 0, len(t))
+	for name := range t {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func (t Tables) hasWebhookURL() bool {
+	for _, c := range t {
+		if c.WebhookURL != "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseList parses a comma separated list.
+func parseList(s string) []string {
+	list := []string{}
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+
+	return list
+}
+
+// parsePairs parses a comma separated list of `key=value` pairs.
+func parsePairs(s string) map[string]string {
+	pairs := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			continue
+		}
+		pairs[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+
+	return pairs
+}
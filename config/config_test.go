@@ -0,0 +1,422 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func env(vars map[string]string) []string {
+	environ := []string{}
+	for key, value := range vars {
+		environ = append(environ, key+"="+value)
+	}
+
+	return environ
+}
+
+func TestLoad(t *testing.T) {
+	c, err := load("testdata/pg2kafka.yml", env(nil))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if c.DatabaseURL != "postgres://localhost/shop_test?sslmode=disable" {
+		t.Errorf("Unexpected database_url %q", c.DatabaseURL)
+	}
+
+	if !c.PerformMigrations {
+		t.Error("Expected perform_migrations to be true")
+	}
+
+	if c.ShutdownTimeout != time.Minute {
+		t.Errorf("Expected shutdown_timeout of 1m, got %v", c.ShutdownTimeout)
+	}
+
+	if c.QueryTimeout != 30*time.Second {
+		t.Errorf("Expected default query_timeout of 30s, got %v", c.QueryTimeout)
+	}
+
+	if *c.Sink.Webhook.MaxRetries != 3 {
+		t.Errorf("Expected default webhook max_retries of 3, got %d", *c.Sink.Webhook.MaxRetries)
+	}
+
+	if c.SchemaCacheTTL != time.Minute {
+		t.Errorf("Expected default schema_cache_ttl of 1m, got %v", c.SchemaCacheTTL)
+	}
+
+	if c.Health.MaxBacklogAge != 10*time.Minute {
+		t.Errorf("Expected max_backlog_age of 10m, got %v", c.Health.MaxBacklogAge)
+	}
+
+	users := c.Tables.Get("users")
+	if users.Topic != "users-changes" || users.Key != "email" || users.Format != FormatJSON {
+		t.Errorf("Unexpected users configuration: %+v", users)
+	}
+
+	if !reflect.DeepEqual(users.Filters.Statements, []string{"INSERT", "UPDATE"}) {
+		t.Errorf("Unexpected users filters: %+v", users.Filters)
+	}
+
+	if !users.Headers {
+		t.Errorf("Expected users to inherit headers from the %q section", AllTables)
+	}
+
+	if products := c.Tables.Get("products"); !products.Headers {
+		t.Errorf("Expected products to fall back to the %q section, got %+v", AllTables, products)
+	}
+}
+
+func TestLoad_Env(t *testing.T) {
+	c, err := load("testdata/pg2kafka.yml", env(map[string]string{
+		"DATABASE_URL":        "postgres://localhost/other",
+		"PERFORM_MIGRATIONS":  "false",
+		"DRY_RUN":             "true",
+		"QUERY_TIMEOUT":       "5s",
+		"DRIFT_REPAIR":        "true",
+		"COALESCE_EVENTS":     "true",
+		"DIRECT_DATABASE_URL": "postgres://db-primary/other",
+		"KAFKA_HEADERS":       "orders",
+		"WEBHOOK_TABLE_URLS":  "users=https://example.com/users",
+		"TABLE_FORMATS":       "orders=protobuf",
+		"WEBHOOK_MAX_RETRIES": "0",
+	}))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if c.DatabaseURL != "postgres://localhost/other" {
+		t.Errorf("Expected DATABASE_URL to override database_url, got %q", c.DatabaseURL)
+	}
+
+	if c.PerformMigrations {
+		t.Error("Expected PERFORM_MIGRATIONS to override perform_migrations")
+	}
+
+	if c.Sink.Type != SinkStdout {
+		t.Errorf("Expected DRY_RUN to select the stdout sink, got %q", c.Sink.Type)
+	}
+
+	if c.QueryTimeout != 5*time.Second {
+		t.Errorf("Expected QUERY_TIMEOUT to override query_timeout, got %v", c.QueryTimeout)
+	}
+
+	if *c.Sink.Webhook.MaxRetries != 0 {
+		t.Errorf("Expected WEBHOOK_MAX_RETRIES to disable retries, got %d", *c.Sink.Webhook.MaxRetries)
+	}
+
+	if !c.Drift.Repair || c.Drift.Interval != 5*time.Minute {
+		t.Errorf("Expected DRIFT_REPAIR to enable repairs with the default interval, got %+v", c.Drift)
+	}
+
+	if c.DirectURL() != "postgres://db-primary/other" {
+		t.Errorf("Expected DIRECT_DATABASE_URL to set the direct url, got %q", c.DirectURL())
+	}
+
+	if !c.Coalesce {
+		t.Error("Expected COALESCE_EVENTS to enable coalescing")
+	}
+
+	if orders := c.Tables.Get("orders"); !orders.Headers || orders.Format != FormatProtobuf {
+		t.Errorf("Expected KAFKA_HEADERS and TABLE_FORMATS to configure orders, got %+v", orders)
+	}
+
+	users := c.Tables.Get("users")
+	if users.WebhookURL != "https://example.com/users" || users.Topic != "users-changes" {
+		t.Errorf("Expected WEBHOOK_TABLE_URLS to extend the users section, got %+v", users)
+	}
+}
+
+func TestLoad_EnvInheritsAllTables(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pg2kafka")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // nolint: errcheck
+
+	path := filepath.Join(dir, "pg2kafka.yml")
+	err = ioutil.WriteFile(path, []byte(`
+database_url: postgres://localhost/shop_test
+sink:
+  type: stdout
+tables:
+  "*":
+    format: protobuf
+    filters:
+      statements: [INSERT]
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := load(path, env(map[string]string{"KAFKA_HEADERS": "users"}))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	users := c.Tables.Get("users")
+	if !users.Headers || users.Format != FormatProtobuf || !reflect.DeepEqual(users.Filters.Statements, []string{"INSERT"}) {
+		t.Errorf("Expected users section to inherit from the %q section, got %+v", AllTables, users)
+	}
+}
+
+func TestLoad_EnvOnly(t *testing.T) {
+	c, err := load("", env(map[string]string{
+		"DATABASE_URL": "postgres://localhost/shop_test",
+		"KAFKA_BROKER": "localhost:9092",
+	}))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if c.Sink.Type != SinkKafka || c.Sink.Kafka.Broker != "localhost:9092" {
+		t.Errorf("Unexpected sink configuration: %+v", c.Sink)
+	}
+
+	if c.HTTPAddr != ":8080" {
+		t.Errorf("Expected default http_addr ':8080', got %q", c.HTTPAddr)
+	}
+
+	if c.PollInterval != time.Minute || c.MinPollInterval != 100*time.Millisecond {
+		t.Errorf("Expected default poll intervals of 1m and 100ms, got %v and %v", c.PollInterval, c.MinPollInterval)
+	}
+
+	if c.DirectURL() != c.DatabaseURL {
+		t.Errorf("Expected direct url to default to database_url, got %q", c.DirectURL())
+	}
+
+	if c.BatchSize != 1000 || c.MaxBatchBytes != 0 {
+		t.Errorf("Expected default batch size of 1000 without byte limit, got %d and %d", c.BatchSize, c.MaxBatchBytes)
+	}
+}
+
+func TestLoad_KafkaProperties(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pg2kafka")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // nolint: errcheck
+
+	password := filepath.Join(dir, "password")
+	if err = ioutil.WriteFile(password, []byte("s3cret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := load("testdata/pg2kafka.yml", env(map[string]string{
+		"KAFKA_SECURITY_PROTOCOL":  "sasl_ssl",
+		"KAFKA_SASL_MECHANISMS":    "SCRAM-SHA-512",
+		"KAFKA_SASL_USERNAME":      "pg2kafka",
+		"KAFKA_SASL_PASSWORD_FILE": password,
+		"KAFKA_HEADERS":            "orders",
+
+		// Set by Kubernetes for a service named kafka.
+		"KAFKA_PORT":               "tcp://10.0.0.1:9092",
+		"KAFKA_PORT_9092_TCP_ADDR": "10.0.0.1",
+		"KAFKA_SERVICE_HOST":       "10.0.0.1",
+		"KAFKA_SERVICE_PORT":       "9092",
+	}))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := map[string]string{
+		"security.protocol": "sasl_ssl",
+		"sasl.mechanisms":   "SCRAM-SHA-512",
+		"sasl.username":     "pg2kafka",
+		"sasl.password":     "s3cret",
+	}
+	if !reflect.DeepEqual(c.Sink.Kafka.Properties, expected) {
+		t.Errorf("Unexpected kafka properties: %v, want: %v", c.Sink.Kafka.Properties, expected)
+	}
+}
+
+var loadErrorTests = []struct {
+	name string
+	path string
+	env  map[string]string
+	errs []string
+}{
+	{
+		"missing file",
+		"testdata/missing.yml",
+		nil,
+		[]string{"error reading config file"},
+	},
+	{
+		"missing settings",
+		"",
+		nil,
+		[]string{"database_url (DATABASE_URL) is required", "sink.kafka.broker (KAFKA_BROKER) is required"},
+	},
+	{
+		"unknown sink",
+		"testdata/pg2kafka.yml",
+		map[string]string{"SINK": "carrier-pigeon"},
+		[]string{`sink.type (SINK) "carrier-pigeon" is unknown`},
+	},
+	{
+		"webhook without url",
+		"",
+		map[string]string{"DATABASE_URL": "postgres://localhost/shop_test", "SINK": "webhook"},
+		[]string{"sink.webhook.url (WEBHOOK_URL) or a webhook_url per table is required"},
+	},
+	{
+		"redis without url",
+		"",
+		map[string]string{"DATABASE_URL": "postgres://localhost/shop_test", "SINK": "redis"},
+		[]string{"sink.redis.url (REDIS_URL) is required for the redis sink"},
+	},
+	{
+		"webhook with protobuf",
+		"",
+		map[string]string{
+			"DATABASE_URL":  "postgres://localhost/shop_test",
+			"SINK":          "webhook",
+			"WEBHOOK_URL":   "http://localhost/events",
+			"TABLE_FORMATS": "products=protobuf",
+		},
+		[]string{"tables.products.format: protobuf can not be used with the webhook sink"},
+	},
+	{
+		"webhook with binary cloudevents",
+		"",
+		map[string]string{
+			"DATABASE_URL":  "postgres://localhost/shop_test",
+			"SINK":          "webhook",
+			"WEBHOOK_URL":   "http://localhost/events",
+			"TABLE_FORMATS": "products=cloudevents-binary",
+		},
+		[]string{"tables.products.format: cloudevents-binary can not be used with the webhook sink"},
+	},
+	{
+		"invalid duration",
+		"testdata/pg2kafka.yml",
+		map[string]string{"SHUTDOWN_TIMEOUT": "soon"},
+		[]string{"invalid SHUTDOWN_TIMEOUT"},
+	},
+	{
+		"poll interval shorter than minimum",
+		"testdata/pg2kafka.yml",
+		map[string]string{"POLL_ONLY": "true", "POLL_INTERVAL": "10ms"},
+		[]string{"poll_interval (POLL_INTERVAL) can not be shorter than min_poll_interval (MIN_POLL_INTERVAL)"},
+	},
+	{
+		"negative batch size",
+		"testdata/pg2kafka.yml",
+		map[string]string{"BATCH_SIZE": "-1"},
+		[]string{"batch_size (BATCH_SIZE) and max_batch_bytes (MAX_BATCH_BYTES) can not be negative"},
+	},
+	{
+		"missing kafka property file",
+		"testdata/pg2kafka.yml",
+		map[string]string{"KAFKA_SASL_PASSWORD_FILE": "testdata/missing"},
+		[]string{"error reading kafka property sasl.password"},
+	},
+	{
+		"kafka property set twice",
+		"testdata/pg2kafka.yml",
+		map[string]string{"KAFKA_SASL_PASSWORD": "s3cret", "KAFKA_SASL_PASSWORD_FILE": "testdata/pg2kafka.yml"},
+		[]string{"kafka property sasl.password is set both directly and from a file"},
+	},
+	{
+		"incomplete sasl credentials",
+		"testdata/pg2kafka.yml",
+		map[string]string{"KAFKA_SECURITY_PROTOCOL": "SASL_SSL", "KAFKA_SASL_MECHANISMS": "PLAIN"},
+		[]string{"sasl.username and sasl.password are required for sasl mechanism PLAIN"},
+	},
+	{
+		"invalid kafka properties",
+		"testdata/pg2kafka.yml",
+		map[string]string{"KAFKA_SECURITY_PROTOCOL": "tls", "KAFKA_SSL_CA_LOCATION": "testdata/missing.pem"},
+		[]string{`unknown protocol "tls"`, "ssl.ca.location: stat testdata/missing.pem"},
+	},
+}
+
+func TestLoad_Errors(t *testing.T) {
+	for _, tt := range loadErrorTests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := load(tt.path, env(tt.env))
+			if err == nil {
+				t.Fatal("Expected an error, got nil")
+			}
+
+			for _, e := range tt.errs {
+				if !strings.Contains(err.Error(), e) {
+					t.Errorf("Expected error to contain %q, got: %v", e, err)
+				}
+			}
+		})
+	}
+}
+
+func TestValidate_Tables(t *testing.T) {
+	c := &Config{
+		DatabaseURL: "postgres://localhost/shop_test",
+		Sink:        SinkConfig{Type: SinkStdout},
+		Tables: Tables{
+			"users": &TableConfig{
+				Format:     "xml",
+				Filters:    Filters{Statements: []string{"TRUNCATE"}},
+				Transforms: Transforms{Rename: map[string]string{"email": ""}},
+			},
+		},
+	}
+
+	err := c.Validate()
+	if err == nil {
+		t.Fatal("Expected an error, got nil")
+	}
+
+	expected := []string{
+		`tables.users.format: unknown format "xml"`,
+		`tables.users.filters.statements: unknown statement "TRUNCATE"`,
+		`tables.users.transforms.rename: empty name for column "email"`,
+	}
+	for _, e := range expected {
+		if !strings.Contains(err.Error(), e) {
+			t.Errorf("Expected error to contain %q, got: %v", e, err)
+		}
+	}
+}
+
+var parseListTests = []struct {
+	in  string
+	out []string
+}{
+	{"", []string{}},
+	{"*", []string{"*"}},
+	{"users, products,", []string{"users", "products"}},
+}
+
+func TestParseList(t *testing.T) {
+	for _, tt := range parseListTests {
+		t.Run(tt.in, func(t *testing.T) {
+			if actual := parseList(tt.in); !reflect.DeepEqual(actual, tt.out) {
+				t.Errorf("parseList(%q) => %v, want: %v", tt.in, actual, tt.out)
+			}
+		})
+	}
+}
+
+var parsePairsTests = []struct {
+	in  string
+	out map[string]string
+}{
+	{"", map[string]string{}},
+	{"users=https://example.com/users", map[string]string{"users": "https://example.com/users"}},
+	{"users=a, products=b?c=d,invalid", map[string]string{"users": "a", "products": "b?c=d"}},
+}
+
+func TestParsePairs(t *testing.T) {
+	for _, tt := range parsePairsTests {
+		t.Run(tt.in, func(t *testing.T) {
+			if actual := parsePairs(tt.in); !reflect.DeepEqual(actual, tt.out) {
+				t.Errorf("parsePairs(%q) => %v, want: %v", tt.in, actual, tt.out)
+			}
+		})
+	}
+}
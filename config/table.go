@@ -0,0 +1,66 @@
+package config
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+// Transforms modify the data of the events of a table before they are
+// published.
+type Transforms struct {
+	// Exclude lists columns that are removed from the data, for example to
+	// keep sensitive data out of the event stream.
+	Exclude []string `yaml:"exclude"`
+
+	// Rename maps column names to the name they are published as.
+	Rename map[string]string `yaml:"rename"`
+}
+
+// Filters select which events of a table are published. Events that are
+// filtered out are marked as processed without being published.
+type Filters struct {
+	// Statements lists the statements that are published, all statements are
+	// published if it is empty.
+	Statements []string `yaml:"statements"`
+}
+
+// Apply applies the transforms to the given event data.
+func (t Transforms) Apply(data json.RawMessage) (json.RawMessage, error) {
+	if len(t.Exclude) == 0 && len(t.Rename) == 0 {
+		return data, nil
+	}
+
+	columns := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &columns); err != nil {
+		return nil, errors.Wrap(err, "error parsing event data")
+	}
+
+	for _, column := range t.Exclude {
+		delete(columns, column)
+	}
+
+	for from, to := range t.Rename {
+		if v, ok := columns[from]; ok {
+			delete(columns, from)
+			columns[to] = v
+		}
+	}
+
+	return json.Marshal(columns)
+}
+
+// Match returns whether an event with the given statement is published.
+func (f Filters) Match(statement string) bool {
+	if len(f.Statements) == 0 {
+		return true
+	}
+
+	for _, s := range f.Statements {
+		if s == statement {
+			return true
+		}
+	}
+
+	return false
+}
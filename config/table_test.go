@@ -0,0 +1,52 @@
+package config
+
+import (
+	"testing"
+)
+
+var transformsApplyTests = []struct {
+	name       string
+	transforms Transforms
+	in         string
+	out        string
+}{
+	{"none", Transforms{}, `{"b": 1, "a": 2}`, `{"b": 1, "a": 2}`},
+	{"exclude", Transforms{Exclude: []string{"password"}}, `{"email": "j@blendle.com", "password": "secret"}`, `{"email":"j@blendle.com"}`},        // nolint: lll
+	{"rename", Transforms{Rename: map[string]string{"email": "email_address"}}, `{"email": "j@blendle.com"}`, `{"email_address":"j@blendle.com"}`}, // nolint: lll
+	{"missing column", Transforms{Exclude: []string{"password"}}, `{}`, `{}`},
+}
+
+func TestTransforms_Apply(t *testing.T) {
+	for _, tt := range transformsApplyTests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual, err := tt.transforms.Apply([]byte(tt.in))
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if string(actual) != tt.out {
+				t.Errorf("Apply(%s) => %s, want: %s", tt.in, actual, tt.out)
+			}
+		})
+	}
+}
+
+var filtersMatchTests = []struct {
+	filters   Filters
+	statement string
+	out       bool
+}{
+	{Filters{}, "UPDATE", true},
+	{Filters{Statements: []string{"INSERT", "UPDATE"}}, "UPDATE", true},
+	{Filters{Statements: []string{"INSERT", "UPDATE"}}, "DELETE", false},
+}
+
+func TestFilters_Match(t *testing.T) {
+	for _, tt := range filtersMatchTests {
+		t.Run(tt.statement, func(t *testing.T) {
+			if actual := tt.filters.Match(tt.statement); actual != tt.out {
+				t.Errorf("%+v.Match(%q) => %v, want: %v", tt.filters, tt.statement, actual, tt.out)
+			}
+		})
+	}
+}
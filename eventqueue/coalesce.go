@@ -0,0 +1,90 @@
+package eventqueue
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+// coalesceKey identifies the row an event belongs to.
+type coalesceKey struct {
+	schema, table, externalID string
+}
+
+// Coalesce merges the events of the same row, so only its latest state is
+// published. Updates are merged into the preceding insert, snapshot or update
+// of the row, and a delete replaces whatever preceded it. The merged event
+// takes the place of the first event it replaces, with the metadata of the
+// last one, and records the IDs of the events folded into it in Coalesced.
+// Events without an external ID are left alone, and a schema change of a
+// table stops its later events from being merged with earlier ones.
+func Coalesce(events []*Event) ([]*Event, error) {
+	coalesced := make([]*Event, 0, len(events))
+	rows := map[coalesceKey]int{}
+
+	for _, event := range events {
+		if event.Statement == StatementSchema {
+			for k := range rows {
+				if k.schema == event.Schema && k.table == event.TableName {
+					delete(rows, k)
+				}
+			}
+		}
+
+		if event.ExternalID == nil {
+			coalesced = append(coalesced, event)
+			continue
+		}
+
+		key := coalesceKey{event.Schema, event.TableName, string(event.ExternalID)}
+		i, ok := rows[key]
+		if !ok {
+			rows[key] = len(coalesced)
+			coalesced = append(coalesced, event)
+			continue
+		}
+
+		merged, err := merge(coalesced[i], event)
+		if err != nil {
+			return nil, err
+		}
+		if merged == nil {
+			rows[key] = len(coalesced)
+			coalesced = append(coalesced, event)
+			continue
+		}
+		coalesced[i] = merged
+	}
+
+	return coalesced, nil
+}
+
+// merge returns the event replacing prev and next, or nil if they cannot be
+// merged, such as an insert following a delete.
+func merge(prev, next *Event) (*Event, error) {
+	merged := *next
+	merged.Coalesced = append(append([]int{}, prev.Coalesced...), prev.ID)
+
+	switch {
+	case next.Statement == "DELETE":
+		return &merged, nil
+	case next.Statement == "UPDATE" && prev.Statement != "DELETE":
+		columns := map[string]json.RawMessage{}
+		for _, data := range []json.RawMessage{prev.Data, next.Data} {
+			if err := json.Unmarshal(data, &columns); err != nil {
+				return nil, errors.Wrapf(err, "error parsing data of event %s", next.UUID)
+			}
+		}
+
+		data, err := json.Marshal(columns)
+		if err != nil {
+			return nil, err
+		}
+
+		merged.Statement = prev.Statement
+		merged.Data = data
+		return &merged, nil
+	default:
+		return nil, nil
+	}
+}
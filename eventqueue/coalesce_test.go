@@ -0,0 +1,131 @@
+package eventqueue
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+var coalesceTests = []struct {
+	name     string
+	events   []*Event
+	expected []string
+}{
+	{
+		"updates",
+		[]*Event{
+			{ID: 1, ExternalID: []byte("a"), Statement: "UPDATE", Data: []byte(`{"price":1}`)},
+			{ID: 2, ExternalID: []byte("b"), Statement: "UPDATE", Data: []byte(`{"price":5}`)},
+			{ID: 3, ExternalID: []byte("a"), Statement: "UPDATE", Data: []byte(`{"price":2,"name":"A"}`)},
+			{ID: 4, ExternalID: []byte("a"), Statement: "UPDATE", Data: []byte(`{"price":3}`)},
+		},
+		[]string{
+			`4 UPDATE {"name":"A","price":3} [1 3]`,
+			`2 UPDATE {"price":5} []`,
+		},
+	},
+	{
+		"insert and updates",
+		[]*Event{
+			{ID: 1, ExternalID: []byte("a"), Statement: "INSERT", Data: []byte(`{"sku":"a","price":1}`)},
+			{ID: 2, ExternalID: []byte("a"), Statement: "UPDATE", Data: []byte(`{"price":2}`)},
+		},
+		[]string{`2 INSERT {"price":2,"sku":"a"} [1]`},
+	},
+	{
+		"interleaved rows",
+		[]*Event{
+			{ID: 1, ExternalID: []byte("a"), Statement: "INSERT", Data: []byte(`{"sku":"a"}`)},
+			{ID: 2, ExternalID: []byte("b"), Statement: "INSERT", Data: []byte(`{"sku":"b"}`)},
+			{ID: 3, ExternalID: []byte("a"), Statement: "UPDATE", Data: []byte(`{"price":1}`)},
+			{ID: 4, ExternalID: []byte("c"), Statement: "UPDATE", Data: []byte(`{"price":5}`)},
+			{ID: 5, ExternalID: []byte("b"), Statement: "UPDATE", Data: []byte(`{"price":2}`)},
+			{ID: 6, ExternalID: []byte("a"), Statement: "UPDATE", Data: []byte(`{"price":3}`)},
+			{ID: 7, ExternalID: []byte("c"), Statement: "DELETE", Data: []byte(`{}`)},
+		},
+		[]string{
+			`6 INSERT {"price":3,"sku":"a"} [1 3]`,
+			`5 INSERT {"price":2,"sku":"b"} [2]`,
+			`7 DELETE {} [4]`,
+		},
+	},
+	{
+		"delete",
+		[]*Event{
+			{ID: 1, ExternalID: []byte("a"), Statement: "INSERT", Data: []byte(`{"sku":"a"}`)},
+			{ID: 2, ExternalID: []byte("a"), Statement: "UPDATE", Data: []byte(`{"price":2}`)},
+			{ID: 3, ExternalID: []byte("a"), Statement: "DELETE", Data: []byte(`{}`)},
+		},
+		[]string{`3 DELETE {} [1 2]`},
+	},
+	{
+		"insert after delete",
+		[]*Event{
+			{ID: 1, ExternalID: []byte("a"), Statement: "DELETE", Data: []byte(`{}`)},
+			{ID: 2, ExternalID: []byte("a"), Statement: "INSERT", Data: []byte(`{"sku":"a"}`)},
+			{ID: 3, ExternalID: []byte("a"), Statement: "UPDATE", Data: []byte(`{"price":2}`)},
+		},
+		[]string{
+			`1 DELETE {} []`,
+			`3 INSERT {"price":2,"sku":"a"} [2]`,
+		},
+	},
+	{
+		"without external id",
+		[]*Event{
+			{ID: 1, Statement: "UPDATE", Data: []byte(`{"price":1}`)},
+			{ID: 2, Statement: "UPDATE", Data: []byte(`{"price":2}`)},
+		},
+		[]string{
+			`1 UPDATE {"price":1} []`,
+			`2 UPDATE {"price":2} []`,
+		},
+	},
+	{
+		"schema change",
+		[]*Event{
+			{ID: 1, ExternalID: []byte("a"), Statement: "UPDATE", Data: []byte(`{"price":1}`)},
+			{ID: 2, Statement: StatementSchema, Data: []byte(`{"columns":[]}`)},
+			{ID: 3, ExternalID: []byte("a"), Statement: "UPDATE", Data: []byte(`{"cost":2}`)},
+		},
+		[]string{
+			`1 UPDATE {"price":1} []`,
+			`2 SCHEMA {"columns":[]} []`,
+			`3 UPDATE {"cost":2} []`,
+		},
+	},
+}
+
+func TestCoalesce(t *testing.T) {
+	for _, tt := range coalesceTests {
+		t.Run(tt.name, func(t *testing.T) {
+			coalesced, err := Coalesce(tt.events)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			actual := []string{}
+			for _, e := range coalesced {
+				ids := e.Coalesced
+				if ids == nil {
+					ids = []int{}
+				}
+				actual = append(actual, fmt.Sprintf("%d %s %s %v", e.ID, e.Statement, e.Data, ids))
+			}
+			if !reflect.DeepEqual(actual, tt.expected) {
+				t.Errorf("Expected %q, got %q", tt.expected, actual)
+			}
+		})
+	}
+}
+
+func TestCoalesce_InvalidData(t *testing.T) {
+	events := []*Event{
+		{ID: 1, ExternalID: []byte("a"), Statement: "UPDATE", Data: []byte(`{}`)},
+		{ID: 2, ExternalID: []byte("a"), Statement: "UPDATE", Data: []byte(`[]`)},
+	}
+
+	if _, err := Coalesce(events); err == nil {
+		t.Error("Expected error merging invalid data")
+	}
+}
@@ -0,0 +1,180 @@
+package eventqueue
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const selectTableColumnsQuery = `
+	SELECT attname, format_type(atttypid, atttypmod), NOT attnotnull, attnum
+	FROM pg_attribute
+	WHERE attrelid = to_regclass($1)
+	AND attnum > 0
+	AND NOT attisdropped
+	ORDER BY attnum
+`
+
+// ErrTableNotFound is returned when the schema of a table is fetched after the
+// table was dropped or renamed.
+var ErrTableNotFound = errors.New("table does not exist")
+
+// TableSchema describes the columns of a table.
+type TableSchema struct {
+	Columns []*Column `json:"columns"`
+}
+
+// Column describes a column of a table, with its type as formatted by
+// Postgres, such as `numeric(10,2)` or `timestamp without time zone`.
+type Column struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Nullable bool   `json:"nullable"`
+
+	// Position is the number of the column in the table, which does not
+	// change when other columns are added or dropped.
+	Position int `json:"position,omitempty"`
+}
+
+// TableSchema fetches the current columns of a table.
+func (eq *Queue) TableSchema(ctx context.Context, table string) (*TableSchema, error) {
+	ctx, cancel := eq.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := eq.db.QueryContext(ctx, selectTableColumnsQuery, table)
+	if err != nil {
+		return nil, err
+	}
+
+	schema := &TableSchema{Columns: []*Column{}}
+	for rows.Next() {
+		c := &Column{}
+		if err = rows.Scan(&c.Name, &c.Type, &c.Nullable, &c.Position); err != nil {
+			rows.Close() // nolint: errcheck
+			return nil, err
+		}
+		schema.Columns = append(schema.Columns, c)
+	}
+
+	if cerr := rows.Close(); cerr != nil {
+		return nil, cerr
+	}
+	if len(schema.Columns) == 0 {
+		return nil, errors.Wrap(ErrTableNotFound, table)
+	}
+	return schema, rows.Err()
+}
+
+// SchemaCache caches the schemas of tables, so they are not fetched for every
+// event.
+type SchemaCache struct {
+	fetch func(ctx context.Context, table string) (*TableSchema, error)
+	ttl   time.Duration
+	now   func() time.Time
+
+	mu      sync.Mutex
+	schemas map[string]*cachedSchema
+}
+
+type cachedSchema struct {
+	schema  *TableSchema
+	expires time.Time
+}
+
+// NewSchemaCache creates a SchemaCache fetching schemas using fetch, usually
+// Queue.TableSchema. Cached schemas are fetched again once they are older than
+// ttl, so dropped columns and changed column types are picked up without
+// schema change events. They don't expire when ttl is zero.
+func NewSchemaCache(
+	fetch func(ctx context.Context, table string) (*TableSchema, error),
+	ttl time.Duration,
+) *SchemaCache {
+	return &SchemaCache{
+		fetch:   fetch,
+		ttl:     ttl,
+		now:     time.Now,
+		schemas: map[string]*cachedSchema{},
+	}
+}
+
+// Get returns the schema of the table of an event. The schema is fetched when
+// it is not cached yet, has expired, or when the event has a column the cached
+// schema does not, which means the table was altered since it was cached. If
+// the table no longer exists, the cached schema is returned if there is one,
+// and otherwise an error with ErrTableNotFound as its cause.
+func (c *SchemaCache) Get(ctx context.Context, event *Event) (*TableSchema, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cached, ok := c.schemas[event.TableName]
+	if ok && !c.expired(cached) && cached.schema.describes(event.Data) {
+		return cached.schema, nil
+	}
+
+	schema, err := c.fetch(ctx, event.TableName)
+	if ok && errors.Cause(err) == ErrTableNotFound {
+		return cached.schema, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "error fetching schema of %s", event.TableName)
+	}
+
+	c.store(event.TableName, schema)
+	return schema, nil
+}
+
+// Update caches the schema recorded by a schema change event.
+func (c *SchemaCache) Update(event *Event) error {
+	schema := &TableSchema{}
+	if err := json.Unmarshal(event.Data, schema); err != nil {
+		return errors.Wrapf(err, "error parsing schema change event %s", event.UUID)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.store(event.TableName, schema)
+	return nil
+}
+
+func (c *SchemaCache) store(table string, schema *TableSchema) {
+	cached := &cachedSchema{schema: schema}
+	if c.ttl > 0 {
+		cached.expires = c.now().Add(c.ttl)
+	}
+	c.schemas[table] = cached
+}
+
+func (c *SchemaCache) expired(cached *cachedSchema) bool {
+	return !cached.expires.IsZero() && !c.now().Before(cached.expires)
+}
+
+// describes returns whether the schema has every column in the data.
+func (s *TableSchema) describes(data json.RawMessage) bool {
+	columns := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &columns); err != nil {
+		return false
+	}
+
+	for name := range columns {
+		if s.Column(name) == nil {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Column returns the column with the given name, or nil if it does not exist.
+func (s *TableSchema) Column(name string) *Column {
+	for _, c := range s.Columns {
+		if c.Name == name {
+			return c
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,128 @@
+package eventqueue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestSchemaCache(t *testing.T) {
+	fetched := 0
+	current := &TableSchema{Columns: []*Column{{Name: "id", Type: "bigint"}}}
+	cache := NewSchemaCache(func(ctx context.Context, table string) (*TableSchema, error) {
+		fetched++
+		return current, nil
+	}, 0)
+
+	event := &Event{TableName: "products", Data: []byte(`{"id": 1}`)}
+	for i := 0; i < 2; i++ {
+		schema, err := cache.Get(context.Background(), event)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if schema != current {
+			t.Errorf("Expected current schema, got %+v", schema)
+		}
+	}
+	if fetched != 1 {
+		t.Errorf("Expected schema to be fetched once, got %d", fetched)
+	}
+
+	// The table was altered without a schema change event.
+	current = &TableSchema{Columns: []*Column{{Name: "id", Type: "bigint"}, {Name: "price", Type: "numeric"}}}
+	event.Data = []byte(`{"id": 1, "price": 9.95}`)
+	schema, err := cache.Get(context.Background(), event)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if fetched != 2 || schema.Column("price") == nil {
+		t.Errorf("Expected schema to be fetched again for new column, got %+v", schema)
+	}
+
+	err = cache.Update(&Event{
+		TableName: "products",
+		Statement: StatementSchema,
+		Data:      []byte(`{"columns": [{"name": "id", "type": "integer", "nullable": false}]}`),
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	event.Data = []byte(`{"id": 1}`)
+	schema, err = cache.Get(context.Background(), event)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if fetched != 2 || schema.Column("id").Type != "integer" {
+		t.Errorf("Expected schema from schema change event, got %+v", schema.Columns[0])
+	}
+}
+
+func TestSchemaCache_TTL(t *testing.T) {
+	fetched := 0
+	current := &TableSchema{Columns: []*Column{
+		{Name: "id", Type: "integer"},
+		{Name: "price", Type: "integer"},
+	}}
+	cache := NewSchemaCache(func(ctx context.Context, table string) (*TableSchema, error) {
+		fetched++
+		return current, nil
+	}, time.Minute)
+	now := time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+	cache.now = func() time.Time { return now }
+
+	event := &Event{TableName: "products", Data: []byte(`{"id": 1}`)}
+	if _, err := cache.Get(context.Background(), event); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// The price column was dropped, and the type of id changed, which the
+	// data of the event does not reveal.
+	current = &TableSchema{Columns: []*Column{{Name: "id", Type: "bigint"}}}
+	now = now.Add(59 * time.Second)
+	schema, err := cache.Get(context.Background(), event)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if fetched != 1 || schema.Column("price") == nil {
+		t.Errorf("Expected cached schema before the ttl expired, got %+v", schema)
+	}
+
+	now = now.Add(time.Second)
+	if schema, err = cache.Get(context.Background(), event); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if fetched != 2 || schema != current {
+		t.Errorf("Expected schema to be fetched again after the ttl expired, got %+v", schema)
+	}
+}
+
+func TestSchemaCache_TableNotFound(t *testing.T) {
+	cache := NewSchemaCache(func(ctx context.Context, table string) (*TableSchema, error) {
+		return nil, errors.Wrap(ErrTableNotFound, table)
+	}, 0)
+
+	event := &Event{TableName: "products", Data: []byte(`{"id": 1, "price": 9.95}`)}
+	if _, err := cache.Get(context.Background(), event); errors.Cause(err) != ErrTableNotFound {
+		t.Fatalf("Expected ErrTableNotFound, got %v", err)
+	}
+
+	err := cache.Update(&Event{
+		TableName: "products",
+		Statement: StatementSchema,
+		Data:      []byte(`{"columns": [{"name": "id", "type": "bigint", "nullable": false}]}`),
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	schema, err := cache.Get(context.Background(), event)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if schema == nil || schema.Column("id") == nil {
+		t.Errorf("Expected cached schema, got %+v", schema)
+	}
+}
@@ -0,0 +1,122 @@
+package eventqueue
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// Drift problems.
+const (
+	// DriftMissingTable means a tracked table no longer exists, for example
+	// because it was renamed.
+	DriftMissingTable = "missing_table"
+
+	// DriftMissingTrigger means the trigger of a tracked table was dropped,
+	// so its changes are not enqueued.
+	DriftMissingTrigger = "missing_trigger"
+
+	// DriftMissingColumn means the external ID column of a tracked table no
+	// longer exists, so its events get a NULL external ID.
+	DriftMissingColumn = "missing_column"
+)
+
+const (
+	selectDriftQuery = `
+		SELECT
+			relations.table_name,
+			relations.external_id,
+			to_regclass(relations.table_name::text) IS NOT NULL,
+			EXISTS (
+				SELECT 1
+				FROM pg_trigger
+				WHERE tgrelid = to_regclass(relations.table_name::text)
+				AND tgfoid = 'pg2kafka.enqueue_event'::regproc
+				AND NOT tgisinternal
+			),
+			EXISTS (
+				SELECT 1
+				FROM pg_attribute
+				WHERE attrelid = to_regclass(relations.table_name::text)
+				AND attname = relations.external_id
+				AND attnum > 0
+				AND NOT attisdropped
+			)
+		FROM pg2kafka.external_id_relations AS relations
+		ORDER BY relations.table_name
+	`
+
+	installTriggerQuery = `SELECT pg2kafka.install_trigger($1::regclass)`
+)
+
+// Drift is a difference between how a table was set up, and how it is now.
+type Drift struct {
+	Table   string
+	Problem string
+	Detail  string
+}
+
+func (d *Drift) String() string {
+	return fmt.Sprintf("%s: %s", d.Table, d.Detail)
+}
+
+// Verify compares the tracked tables with their triggers and columns, and
+// returns the drift it found.
+func (eq *Queue) Verify(ctx context.Context) ([]*Drift, error) {
+	ctx, cancel := eq.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := eq.db.QueryContext(ctx, selectDriftQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	drift := []*Drift{}
+	for rows.Next() {
+		var table, externalID string
+		var tableExists, triggerExists, columnExists bool
+		err = rows.Scan(&table, &externalID, &tableExists, &triggerExists, &columnExists)
+		if err != nil {
+			rows.Close() // nolint: errcheck
+			return nil, err
+		}
+
+		switch {
+		case !tableExists:
+			drift = append(drift, &Drift{table, DriftMissingTable, "table does not exist"})
+			continue
+		case !triggerExists:
+			drift = append(drift, &Drift{table, DriftMissingTrigger, "trigger enqueueing events does not exist"})
+		}
+		if !columnExists {
+			detail := fmt.Sprintf("external ID column %q does not exist", externalID)
+			drift = append(drift, &Drift{table, DriftMissingColumn, detail})
+		}
+	}
+
+	if cerr := rows.Close(); cerr != nil {
+		return nil, cerr
+	}
+	return drift, rows.Err()
+}
+
+// Repair reinstalls the missing triggers in the given drift, and returns the
+// drift it repaired. Other drift needs a decision by an operator, such as
+// tearing down a table and setting it up with another column. Changes made
+// while a trigger was missing are not recovered; snapshot the table for that.
+func (eq *Queue) Repair(ctx context.Context, drift []*Drift) ([]*Drift, error) {
+	repaired := []*Drift{}
+	for _, d := range drift {
+		if d.Problem != DriftMissingTrigger {
+			continue
+		}
+
+		if _, err := eq.db.ExecContext(ctx, installTriggerQuery, d.Table); err != nil {
+			return repaired, errors.Wrapf(err, "error installing trigger on %s", d.Table)
+		}
+		repaired = append(repaired, d)
+	}
+
+	return repaired, nil
+}
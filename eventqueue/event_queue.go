@@ -1,23 +1,48 @@
 package eventqueue
 
 import (
+	"context"
 	"database/sql"
 	"database/sql/driver"
 	"encoding/json"
-	"io/ioutil"
-	"math"
 	"time"
 
 	"github.com/pkg/errors"
 )
 
 const (
+	// eventColumns are the columns scanned by scanEvents.
+	eventColumns = `
+		id, uuid, external_id, table_name, COALESCE(table_schema, ''), statement,
+		data, source, COALESCE(txid, 0), created_at
+	`
+
+	// selectUnprocessedEventsQuery selects a batch of unprocessed events after
+	// an ID.
 	selectUnprocessedEventsQuery = `
-		SELECT id, uuid, external_id, table_name, statement, data, created_at
+		SELECT ` + eventColumns + `
 		FROM pg2kafka.outbound_event_queue
-		WHERE processed = false
+		WHERE processed = false AND id > $1
+		ORDER BY id ASC
+		LIMIT $2
+	`
+
+	// selectUnprocessedEventsWithinBytesQuery is like
+	// selectUnprocessedEventsQuery, but stops at the event that makes the data
+	// of the batch exceed a byte limit.
+	selectUnprocessedEventsWithinBytesQuery = `
+		SELECT ` + eventColumns + `
+		FROM (
+			SELECT
+				*,
+				sum(octet_length(data::text)) OVER (ORDER BY id) - octet_length(data::text) AS preceding_bytes
+			FROM pg2kafka.outbound_event_queue
+			WHERE processed = false AND id > $1
+			ORDER BY id ASC
+			LIMIT $2
+		) batch
+		WHERE preceding_bytes < $3
 		ORDER BY id ASC
-		LIMIT 1000
 	`
 
 	markEventAsProcessedQuery = `
@@ -27,7 +52,9 @@ const (
 	`
 
 	countUnprocessedEventsQuery = `
-		SELECT count(*) AS count
+		SELECT
+			count(*) AS count,
+			COALESCE(EXTRACT(EPOCH FROM current_timestamp::timestamp - min(created_at)), 0) AS age
 		FROM pg2kafka.outbound_event_queue
 		WHERE processed IS FALSE
 	`
@@ -43,16 +70,57 @@ type Event struct {
 	UUID       string          `json:"uuid"`
 	ExternalID ByteString      `json:"external_id"`
 	TableName  string          `json:"-"`
+	Schema     string          `json:"-"`
 	Statement  string          `json:"statement"`
 	Data       json.RawMessage `json:"data"`
-	CreatedAt  time.Time       `json:"created_at"`
-	Processed  bool            `json:"-"`
+	Source     *Source         `json:"source,omitempty"`
+	TxID       int64           `json:"-"`
+
+	// TableSchema describes the columns of the table, if the table is
+	// configured to include it.
+	TableSchema *TableSchema `json:"schema,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+	Processed bool      `json:"-"`
+
+	// Coalesced holds the IDs of the events merged into this one by Coalesce,
+	// which are processed along with it.
+	Coalesced []int `json:"-"`
+}
+
+// Source describes the database session that caused an event, so consumers
+// can tell who changed a row. ApplicationName and Actor are only set when the
+// session configured `application_name` or the custom `pg2kafka.actor`
+// setting.
+type Source struct {
+	CurrentUser     string `json:"current_user"`
+	SessionUser     string `json:"session_user"`
+	ApplicationName string `json:"application_name,omitempty"`
+	Actor           string `json:"actor,omitempty"`
 }
 
+// Backlog describes the events that have not been processed yet.
+type Backlog struct {
+	// Count is the number of unprocessed events.
+	Count int
+
+	// OldestAge is the time since the oldest unprocessed event was created, or
+	// zero if there are no unprocessed events.
+	OldestAge time.Duration
+}
+
+// DefaultBatchSize is the number of events fetched at once, unless
+// configured otherwise using SetBatchSize.
+const DefaultBatchSize = 1000
+
 // Queue represents the queue of snapshot/create/update/delete events stored in
 // the database.
 type Queue struct {
-	db *sql.DB
+	db           *sql.DB
+	queryTimeout time.Duration
+
+	batchSize     int
+	maxBatchBytes int
 }
 
 // New creates a new Queue, connected to the given database URL.
@@ -70,88 +138,181 @@ func NewWithDB(db *sql.DB) *Queue {
 	return &Queue{db: db}
 }
 
-// FetchUnprocessedRecords fetches a page (up to 1000) of events that have not
-// been marked as processed yet.
+// SetQueryTimeout limits how long the queries of the context-aware methods may
+// take. Queries are not limited when the timeout is zero, which is the
+// default. Migrations are never limited, as they can take a long time on large
+// tables.
+func (eq *Queue) SetQueryTimeout(timeout time.Duration) {
+	eq.queryTimeout = timeout
+}
+
+// SetBatchSize sets the maximum number of events fetched at once, and the
+// maximum combined size of their data in bytes. The first event of a batch is
+// always fetched, however large it is. A size of zero falls back to
+// DefaultBatchSize, and the data is not limited when maxBytes is zero.
+func (eq *Queue) SetBatchSize(size, maxBytes int) {
+	eq.batchSize = size
+	eq.maxBatchBytes = maxBytes
+}
+
+// FetchUnprocessedRecords fetches the first batch of events that have not been
+// marked as processed yet.
 func (eq *Queue) FetchUnprocessedRecords() ([]*Event, error) {
-	rows, err := eq.db.Query(selectUnprocessedEventsQuery)
-	if err != nil {
-		return nil, err
+	return eq.FetchUnprocessedRecordsContext(context.Background())
+}
+
+// FetchUnprocessedRecordsContext is like FetchUnprocessedRecords, but is
+// cancelled when the context is done.
+func (eq *Queue) FetchUnprocessedRecordsContext(ctx context.Context) ([]*Event, error) {
+	return eq.FetchUnprocessedRecordsAfterContext(ctx, 0)
+}
+
+// FetchUnprocessedRecordsAfter fetches the next batch of events that have not
+// been marked as processed yet, with an ID greater than the given one. Passing
+// the ID of the last event of the previous batch pages through the queue,
+// without skipping events that remain unprocessed.
+func (eq *Queue) FetchUnprocessedRecordsAfter(id int) ([]*Event, error) {
+	return eq.FetchUnprocessedRecordsAfterContext(context.Background(), id)
+}
+
+// FetchUnprocessedRecordsAfterContext is like FetchUnprocessedRecordsAfter,
+// but is cancelled when the context is done.
+func (eq *Queue) FetchUnprocessedRecordsAfterContext(ctx context.Context, id int) ([]*Event, error) {
+	ctx, cancel := eq.withTimeout(ctx)
+	defer cancel()
+
+	size := eq.batchSize
+	if size <= 0 {
+		size = DefaultBatchSize
 	}
 
-	messages := []*Event{}
-	for rows.Next() {
-		msg := &Event{}
-		err = rows.Scan(
-			&msg.ID,
-			&msg.UUID,
-			&msg.ExternalID,
-			&msg.TableName,
-			&msg.Statement,
-			&msg.Data,
-			&msg.CreatedAt,
-		)
-		if err != nil {
-			return nil, err
-		}
-		messages = append(messages, msg)
+	// Summing the size of every event is only worth it with a byte limit.
+	query, args := selectUnprocessedEventsQuery, []interface{}{id, size}
+	if eq.maxBatchBytes > 0 {
+		query, args = selectUnprocessedEventsWithinBytesQuery, append(args, eq.maxBatchBytes)
 	}
 
-	if cerr := rows.Close(); cerr != nil {
-		return nil, cerr
+	rows, err := eq.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
 	}
-	return messages, nil
+
+	return scanEvents(rows)
 }
 
-// UnprocessedEventPagesCount returns how many "pages" of events there are
-// queued in the database. Currently page-size is hard-coded to 1000 events per
-// page.
-func (eq *Queue) UnprocessedEventPagesCount() (int, error) {
-	count := 0
-	err := eq.db.QueryRow(countUnprocessedEventsQuery).Scan(&count)
+// UnprocessedEventsBacklog returns how many events are waiting to be
+// processed, and how long the oldest of them has been waiting.
+func (eq *Queue) UnprocessedEventsBacklog() (*Backlog, error) {
+	return eq.UnprocessedEventsBacklogContext(context.Background())
+}
+
+// UnprocessedEventsBacklogContext is like UnprocessedEventsBacklog, but is
+// cancelled when the context is done.
+func (eq *Queue) UnprocessedEventsBacklogContext(ctx context.Context) (*Backlog, error) {
+	ctx, cancel := eq.withTimeout(ctx)
+	defer cancel()
+
+	backlog := &Backlog{}
+	age := 0.0
+	err := eq.db.QueryRowContext(ctx, countUnprocessedEventsQuery).Scan(&backlog.Count, &age)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 
-	limit := 1000
-	return int(math.Ceil(float64(count) / float64(limit))), nil
+	backlog.OldestAge = time.Duration(age * float64(time.Second))
+	return backlog, nil
 }
 
 // MarkEventAsProcessed marks an even as processed.
 func (eq *Queue) MarkEventAsProcessed(eventID int) error {
-	_, err := eq.db.Exec(markEventAsProcessedQuery, eventID)
+	return eq.MarkEventAsProcessedContext(context.Background(), eventID)
+}
+
+// MarkEventAsProcessedContext is like MarkEventAsProcessed, but is cancelled
+// when the context is done.
+func (eq *Queue) MarkEventAsProcessedContext(ctx context.Context, eventID int) error {
+	ctx, cancel := eq.withTimeout(ctx)
+	defer cancel()
+
+	_, err := eq.db.ExecContext(ctx, markEventAsProcessedQuery, eventID)
 	return err
 }
 
+// Ping checks whether the database is reachable.
+func (eq *Queue) Ping() error {
+	return eq.PingContext(context.Background())
+}
+
+// PingContext is like Ping, but is cancelled when the context is done.
+func (eq *Queue) PingContext(ctx context.Context) error {
+	ctx, cancel := eq.withTimeout(ctx)
+	defer cancel()
+
+	return eq.db.PingContext(ctx)
+}
+
 // Close closes the Queue's database connection.
 func (eq *Queue) Close() error {
 	return eq.db.Close()
 }
 
-// ConfigureOutboundEventQueueAndTriggers will set up a new schema 'pg2kafka', with
-// an 'outbound_event_queue' table that is used to store events, and all the
-// triggers necessary to snapshot and start tracking changes for a given table.
-func (eq *Queue) ConfigureOutboundEventQueueAndTriggers(path string) error {
-	migration, err := ioutil.ReadFile(path + "/migrations.sql")
-	if err != nil {
-		return errors.Wrap(err, "error reading migration")
+// withTimeout derives a context that is cancelled after the query timeout.
+func (eq *Queue) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if eq.queryTimeout <= 0 {
+		return context.WithCancel(ctx)
 	}
 
-	_, err = eq.db.Exec(string(migration))
-	if err != nil {
-		return errors.Wrap(err, "failed to create table")
+	return context.WithTimeout(ctx, eq.queryTimeout)
+}
+
+// scanEvents scans and closes rows selecting the eventColumns.
+func scanEvents(rows *sql.Rows) ([]*Event, error) {
+	messages := []*Event{}
+	for rows.Next() {
+		msg := &Event{}
+		var source []byte
+		err := rows.Scan(
+			&msg.ID,
+			&msg.UUID,
+			&msg.ExternalID,
+			&msg.TableName,
+			&msg.Schema,
+			&msg.Statement,
+			&msg.Data,
+			&source,
+			&msg.TxID,
+			&msg.CreatedAt,
+		)
+		if err != nil {
+			rows.Close() // nolint: errcheck
+			return nil, err
+		}
+		if msg.Source, err = parseSource(source); err != nil {
+			rows.Close() // nolint: errcheck
+			return nil, err
+		}
+		messages = append(messages, msg)
 	}
 
-	functions, err := ioutil.ReadFile(path + "/triggers.sql")
-	if err != nil {
-		return errors.Wrap(err, "Error loading functions")
+	if cerr := rows.Close(); cerr != nil {
+		return nil, cerr
 	}
+	return messages, rows.Err()
+}
 
-	_, err = eq.db.Exec(string(functions))
-	if err != nil {
-		return errors.Wrap(err, "Error creating triggers")
+// parseSource parses the `source` column of an event. Events enqueued before
+// the column existed have no source, in which case nil is returned.
+func parseSource(b []byte) (*Source, error) {
+	if b == nil {
+		return nil, nil
 	}
 
-	return nil
+	source := &Source{}
+	if err := json.Unmarshal(b, source); err != nil {
+		return nil, errors.Wrap(err, "error parsing event source")
+	}
+
+	return source, nil
 }
 
 // MarshalJSON implements the json.Marshaler interface.
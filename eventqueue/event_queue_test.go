@@ -2,7 +2,9 @@ package eventqueue
 
 import (
 	"bytes"
+	"context"
 	"testing"
+	"time"
 )
 
 var byteStringMarshalJSONtests = []struct {
@@ -112,3 +114,55 @@ func TestByteString_Scan(t *testing.T) {
 		})
 	}
 }
+
+var parseSourceTests = []struct {
+	in  []byte
+	out *Source
+	err bool
+}{
+	{nil, nil, false},
+	{[]byte(`{"current_user": "app", "session_user": "app"}`), &Source{CurrentUser: "app", SessionUser: "app"}, false},
+	{[]byte(`{"current_user": "app", "session_user": "admin", "application_name": "web", "actor": "user-42"}`), &Source{CurrentUser: "app", SessionUser: "admin", ApplicationName: "web", Actor: "user-42"}, false}, // nolint: lll
+	{[]byte(`{"current_user": "app", "actor": null}`), &Source{CurrentUser: "app"}, false},
+	{[]byte(`[]`), nil, true},
+}
+
+func TestParseSource(t *testing.T) {
+	for _, tt := range parseSourceTests {
+		t.Run(string(tt.in), func(t *testing.T) {
+			actual, err := parseSource(tt.in)
+			if (err == nil) == tt.err {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if tt.err {
+				return
+			}
+
+			if (actual == nil) != (tt.out == nil) || (actual != nil && *actual != *tt.out) {
+				t.Errorf("parseSource(%q) => %+v, want: %+v", tt.in, actual, tt.out)
+			}
+		})
+	}
+}
+
+func TestQueue_WithTimeout(t *testing.T) {
+	eq := &Queue{}
+
+	ctx, cancel := eq.withTimeout(context.Background())
+	defer cancel()
+	if _, ok := ctx.Deadline(); ok {
+		t.Error("Expected no deadline without a query timeout")
+	}
+
+	eq.SetQueryTimeout(time.Minute)
+	ctx, cancel = eq.withTimeout(context.Background())
+	defer cancel()
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		t.Fatal("Expected a deadline with a query timeout")
+	}
+	if time.Until(deadline) > time.Minute {
+		t.Errorf("Expected deadline within a minute, got %v", deadline)
+	}
+}
@@ -0,0 +1,168 @@
+package eventqueue
+
+import (
+	"context"
+	"embed"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// migrationFiles holds the numbered schema migrations. Migrations are never
+// changed once released; schema changes get a new migration instead, so
+// existing installs can be upgraded.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLock is the key of the advisory lock held while migrating, so
+// instances starting at the same time do not migrate concurrently.
+const migrationLock = 0x7067326b61666b61 // "pg2kafka"
+
+const (
+	createMigrationsTableQuery = `
+		CREATE SCHEMA IF NOT EXISTS pg2kafka;
+		CREATE TABLE IF NOT EXISTS pg2kafka.schema_migrations (
+			version    integer PRIMARY KEY,
+			name       varchar(255) NOT NULL,
+			applied_at timestamp NOT NULL DEFAULT current_timestamp
+		);
+	`
+
+	selectMigrationVersionsQuery = `SELECT version FROM pg2kafka.schema_migrations`
+	insertMigrationQuery         = `INSERT INTO pg2kafka.schema_migrations (version, name) VALUES ($1, $2)`
+)
+
+// Migration is a numbered change to the pg2kafka schema.
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// Migrations returns the migrations embedded in the binary, ordered by
+// version.
+func Migrations() ([]*Migration, error) {
+	files, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := make([]*Migration, 0, len(files))
+	for _, f := range files {
+		name := strings.TrimSuffix(f.Name(), ".sql")
+		parts := strings.SplitN(name, "_", 2)
+		version, err := strconv.Atoi(parts[0])
+		if err != nil || len(parts) != 2 {
+			return nil, errors.Errorf("invalid migration name %s, expected <version>_<name>.sql", f.Name())
+		}
+
+		b, err := migrationFiles.ReadFile(path.Join("migrations", f.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		migrations = append(migrations, &Migration{Version: version, Name: parts[1], SQL: string(b)})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	for i := 1; i < len(migrations); i++ {
+		if migrations[i].Version == migrations[i-1].Version {
+			return nil, errors.Errorf("duplicate migration version %d", migrations[i].Version)
+		}
+	}
+
+	return migrations, nil
+}
+
+// Migrate applies the migrations that were not applied to the database yet,
+// each in its own transaction, and returns them. Installs from before
+// migrations were versioned apply every migration once; they are written to
+// be no-ops for what already exists.
+//
+// Migrations are not limited by the query timeout, as they can take a long
+// time on large tables.
+func (eq *Queue) Migrate(ctx context.Context) ([]*Migration, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, errors.Wrap(err, "error loading migrations")
+	}
+
+	// Advisory locks belong to a session, so the lock is taken, used and
+	// released on a single connection.
+	conn, err := eq.db.Conn(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "error connecting to database")
+	}
+	defer conn.Close() // nolint: errcheck
+
+	if _, err = conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLock); err != nil {
+		return nil, errors.Wrap(err, "error acquiring migration lock")
+	}
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, migrationLock) // nolint: errcheck
+
+	if _, err = conn.ExecContext(ctx, createMigrationsTableQuery); err != nil {
+		return nil, errors.Wrap(err, "error creating schema_migrations table")
+	}
+
+	applied := map[int]bool{}
+	rows, err := conn.QueryContext(ctx, selectMigrationVersionsQuery)
+	if err != nil {
+		return nil, errors.Wrap(err, "error selecting applied migrations")
+	}
+	for rows.Next() {
+		var version int
+		if err = rows.Scan(&version); err != nil {
+			rows.Close() // nolint: errcheck
+			return nil, err
+		}
+		applied[version] = true
+	}
+	if err = rows.Close(); err != nil {
+		return nil, err
+	}
+
+	done := []*Migration{}
+	for _, m := range migrations {
+		if applied[m.Version] {
+			continue
+		}
+
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return done, err
+		}
+
+		if _, err = tx.ExecContext(ctx, m.SQL); err != nil {
+			tx.Rollback() // nolint: errcheck
+			return done, errors.Wrapf(err, "error applying migration %d_%s", m.Version, m.Name)
+		}
+		if _, err = tx.ExecContext(ctx, insertMigrationQuery, m.Version, m.Name); err != nil {
+			tx.Rollback() // nolint: errcheck
+			return done, errors.Wrapf(err, "error recording migration %d_%s", m.Version, m.Name)
+		}
+		if err = tx.Commit(); err != nil {
+			return done, errors.Wrapf(err, "error committing migration %d_%s", m.Version, m.Name)
+		}
+
+		done = append(done, m)
+	}
+
+	return done, nil
+}
+
+// ConfigureOutboundEventQueueAndTriggers will set up a new schema 'pg2kafka', with
+// an 'outbound_event_queue' table that is used to store events, and all the
+// triggers necessary to snapshot and start tracking changes for a given table.
+//
+// Deprecated: use Migrate. The path is ignored, as the migrations are embedded
+// in the binary.
+func (eq *Queue) ConfigureOutboundEventQueueAndTriggers(path string) error {
+	_, err := eq.Migrate(context.Background())
+	return err
+}
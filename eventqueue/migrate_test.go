@@ -0,0 +1,31 @@
+package eventqueue
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMigrations(t *testing.T) {
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(migrations) == 0 {
+		t.Fatal("Expected migrations to be embedded")
+	}
+
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			t.Errorf("Expected migration %d to have version %d, got %d", i, i+1, m.Version)
+		}
+
+		if m.Name == "" || strings.TrimSpace(m.SQL) == "" {
+			t.Errorf("Expected migration %d to have a name and SQL, got %+v", m.Version, m)
+		}
+	}
+
+	if migrations[0].Name != "create_outbound_event_queue" {
+		t.Errorf("Expected first migration to be create_outbound_event_queue, got %s", migrations[0].Name)
+	}
+}
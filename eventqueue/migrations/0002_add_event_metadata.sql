@@ -0,0 +1,4 @@
+ALTER TABLE pg2kafka.outbound_event_queue
+ADD COLUMN IF NOT EXISTS source jsonb,
+ADD COLUMN IF NOT EXISTS table_schema varchar(255),
+ADD COLUMN IF NOT EXISTS txid bigint;
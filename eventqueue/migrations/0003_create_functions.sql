@@ -1,3 +1,17 @@
+CREATE OR REPLACE FUNCTION pg2kafka.event_source() RETURNS jsonb
+LANGUAGE sql STABLE
+AS $_$
+  -- The actor is an optional setting applications can use to identify the
+  -- person or service on whose behalf the change is made, for example:
+  -- SET LOCAL pg2kafka.actor = 'user-42';
+  SELECT jsonb_build_object(
+    'current_user', current_user,
+    'session_user', session_user,
+    'application_name', NULLIF(current_setting('application_name', true), ''),
+    'actor', NULLIF(current_setting('pg2kafka.actor', true), '')
+  );
+$_$;
+
 CREATE OR REPLACE FUNCTION pg2kafka.enqueue_event() RETURNS trigger
 LANGUAGE plpgsql
 AS $_$
@@ -36,8 +50,8 @@ BEGIN
     RETURN NULL;
   END IF;
 
-  INSERT INTO pg2kafka.outbound_event_queue(external_id, table_name, statement, data)
-  VALUES (external_id, TG_TABLE_NAME, TG_OP, changes)
+  INSERT INTO pg2kafka.outbound_event_queue(external_id, table_name, table_schema, statement, data, source, txid)
+  VALUES (external_id, TG_TABLE_NAME, TG_TABLE_SCHEMA, TG_OP, changes, pg2kafka.event_source(), txid_current())
   RETURNING * INTO outbound_event;
 
   PERFORM pg_notify('outbound_event_queue', TG_OP);
@@ -55,19 +69,25 @@ DECLARE
   changes jsonb;
   external_id_ref varchar;
   external_id varchar;
+  table_schema varchar;
 BEGIN
   SELECT pg2kafka.external_id_relations.external_id INTO external_id_ref
   FROM pg2kafka.external_id_relations
   WHERE pg2kafka.external_id_relations.table_name = table_name_ref::varchar;
 
+  SELECT pg_namespace.nspname INTO table_schema
+  FROM pg_class
+  JOIN pg_namespace ON pg_namespace.oid = pg_class.relnamespace
+  WHERE pg_class.oid = table_name_ref;
+
   query := 'SELECT * FROM ' || table_name_ref;
 
   FOR rec IN EXECUTE query LOOP
     changes := row_to_json(rec);
     external_id := changes->>external_id_ref;
 
-    INSERT INTO pg2kafka.outbound_event_queue(external_id, table_name, statement, data)
-    VALUES (external_id, table_name_ref, 'SNAPSHOT', changes);
+    INSERT INTO pg2kafka.outbound_event_queue(external_id, table_name, table_schema, statement, data, source, txid)
+    VALUES (external_id, table_name_ref, table_schema, 'SNAPSHOT', changes, pg2kafka.event_source(), txid_current());
   END LOOP;
 
   PERFORM pg_notify('outbound_event_queue', 'SNAPSHOT');
@@ -111,3 +131,19 @@ BEGIN
   EXECUTE trigger_query;
 END
 $_$;
+
+CREATE OR REPLACE FUNCTION pg2kafka.teardown(table_name_ref regclass) RETURNS void
+LANGUAGE plpgsql
+AS $_$
+DECLARE
+  trigger_query varchar;
+BEGIN
+  trigger_query := 'DROP TRIGGER IF EXISTS ' || table_name_ref || '_enqueue_event'
+    || ' ON ' || table_name_ref;
+
+  EXECUTE trigger_query;
+
+  DELETE FROM pg2kafka.external_id_relations
+  WHERE pg2kafka.external_id_relations.table_name = table_name_ref::varchar;
+END
+$_$;
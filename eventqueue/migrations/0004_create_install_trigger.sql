@@ -0,0 +1,15 @@
+CREATE OR REPLACE FUNCTION pg2kafka.install_trigger(table_name_ref regclass) RETURNS void
+LANGUAGE plpgsql
+AS $_$
+DECLARE
+  trigger_query varchar;
+BEGIN
+  trigger_query := 'DROP TRIGGER IF EXISTS ' || table_name_ref || '_enqueue_event'
+    || ' ON ' || table_name_ref || ';'
+    || 'CREATE TRIGGER ' || table_name_ref || '_enqueue_event'
+    || ' AFTER INSERT OR DELETE OR UPDATE ON ' || table_name_ref
+    || ' FOR EACH ROW EXECUTE PROCEDURE pg2kafka.enqueue_event()';
+
+  EXECUTE trigger_query;
+END
+$_$;
@@ -0,0 +1,36 @@
+CREATE OR REPLACE FUNCTION pg2kafka.enqueue_schema_event() RETURNS event_trigger
+LANGUAGE plpgsql
+AS $_$
+DECLARE
+  command record;
+  relation varchar;
+  columns jsonb;
+BEGIN
+  FOR command IN
+    SELECT DISTINCT objid, schema_name
+    FROM pg_event_trigger_ddl_commands()
+    WHERE object_type = 'table'
+  LOOP
+    relation := NULL;
+
+    SELECT pg2kafka.external_id_relations.table_name INTO relation
+    FROM pg2kafka.external_id_relations
+    WHERE to_regclass(pg2kafka.external_id_relations.table_name::text) = command.objid;
+
+    CONTINUE WHEN relation IS NULL;
+
+    SELECT jsonb_agg(jsonb_build_object(
+      'name', attname,
+      'type', format_type(atttypid, atttypmod),
+      'nullable', NOT attnotnull
+    ) ORDER BY attnum) INTO columns
+    FROM pg_attribute
+    WHERE attrelid = command.objid AND attnum > 0 AND NOT attisdropped;
+
+    INSERT INTO pg2kafka.outbound_event_queue(external_id, table_name, table_schema, statement, data, source, txid)
+    VALUES (NULL, relation, command.schema_name, 'SCHEMA', jsonb_build_object('columns', columns), pg2kafka.event_source(), txid_current());
+
+    PERFORM pg_notify('outbound_event_queue', 'SCHEMA');
+  END LOOP;
+END
+$_$;
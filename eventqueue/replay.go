@@ -0,0 +1,119 @@
+package eventqueue
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const selectProcessedEventsQuery = `
+	SELECT ` + eventColumns + `
+	FROM pg2kafka.outbound_event_queue
+	WHERE processed = true
+	AND table_name = $1
+	AND id > $2
+	AND ($3 = 0 OR id <= $3)
+	AND ($4::timestamptz IS NULL OR created_at >= $4::timestamptz)
+	AND ($5::timestamptz IS NULL OR created_at < $5::timestamptz)
+	ORDER BY id ASC
+	LIMIT $6
+`
+
+// ReplayOptions selects the processed events to replay, and how fast.
+type ReplayOptions struct {
+	// Table is the name of the table whose events are replayed.
+	Table string
+
+	// FromID and ToID limit the replayed events to an inclusive range of ids.
+	// Zero means unlimited.
+	FromID int
+	ToID   int
+
+	// Since and Until limit the replayed events to those created in the
+	// half-open interval [Since, Until). The zero time means unlimited.
+	Since time.Time
+	Until time.Time
+
+	// Rate is the maximum number of events replayed per second, so a replay
+	// does not starve live traffic. Zero means unlimited.
+	Rate int
+
+	// BatchSize is the number of events fetched and passed to the publish
+	// function at once, 1000 by default.
+	BatchSize int
+}
+
+// Replay fetches the processed events selected by the options in batches, and
+// passes them to publish in order, without changing their processed state. It
+// returns the number of events published, and stops at the first error.
+func (eq *Queue) Replay(ctx context.Context, opts ReplayOptions, publish func([]*Event) error) (int, error) {
+	if opts.Table == "" {
+		return 0, errors.New("replay needs a table")
+	}
+
+	limit := opts.BatchSize
+	if limit <= 0 {
+		limit = 1000
+	}
+	if opts.Rate > 0 && opts.Rate < limit {
+		limit = opts.Rate
+	}
+
+	start := time.Now()
+	afterID := opts.FromID - 1
+	if afterID < 0 {
+		afterID = 0
+	}
+
+	count := 0
+	for {
+		events, err := eq.fetchProcessedRecords(ctx, opts, afterID, limit)
+		if err != nil {
+			return count, errors.Wrap(err, "error fetching events to replay")
+		}
+		if len(events) == 0 {
+			return count, nil
+		}
+
+		if err = publish(events); err != nil {
+			return count, err
+		}
+		count += len(events)
+		afterID = events[len(events)-1].ID
+
+		if opts.Rate > 0 {
+			// Wait until the events replayed so far are within the rate.
+			due := start.Add(time.Duration(count) * time.Second / time.Duration(opts.Rate))
+			select {
+			case <-time.After(time.Until(due)):
+			case <-ctx.Done():
+				return count, ctx.Err()
+			}
+		}
+	}
+}
+
+func (eq *Queue) fetchProcessedRecords(ctx context.Context, opts ReplayOptions, afterID, limit int) ([]*Event, error) {
+	ctx, cancel := eq.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := eq.db.QueryContext(
+		ctx, selectProcessedEventsQuery,
+		opts.Table, afterID, opts.ToID, nullTime(opts.Since), nullTime(opts.Until), limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanEvents(rows)
+}
+
+// nullTime converts the zero time to NULL.
+func nullTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+
+	return t
+}
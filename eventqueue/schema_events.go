@@ -0,0 +1,33 @@
+package eventqueue
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+// StatementSchema is the statement of events recording a schema change to a
+// tracked table. Their data holds the new columns of the table:
+//
+//	{"columns": [{"name": "id", "type": "bigint", "nullable": false}]}
+const StatementSchema = "SCHEMA"
+
+const enableSchemaEventsQuery = `
+	DO $$
+	BEGIN
+		IF NOT EXISTS (SELECT 1 FROM pg_event_trigger WHERE evtname = 'pg2kafka_schema_events') THEN
+			CREATE EVENT TRIGGER pg2kafka_schema_events ON ddl_command_end
+			WHEN TAG IN ('ALTER TABLE')
+			EXECUTE PROCEDURE pg2kafka.enqueue_schema_event();
+		END IF;
+	END
+	$$
+`
+
+// EnableSchemaEvents installs the event trigger that enqueues an event every
+// time a tracked table is altered. Creating event triggers requires a
+// superuser.
+func (eq *Queue) EnableSchemaEvents(ctx context.Context) error {
+	_, err := eq.db.ExecContext(ctx, enableSchemaEventsQuery)
+	return errors.Wrap(err, "error creating event trigger, which requires a superuser")
+}
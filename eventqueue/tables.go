@@ -0,0 +1,123 @@
+package eventqueue
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	setupTableQuery    = `SELECT pg2kafka.setup($1::regclass, $2)`
+	teardownTableQuery = `SELECT pg2kafka.teardown($1::regclass)`
+	snapshotTableQuery = `SELECT pg2kafka.create_snapshot_events($1::regclass)`
+
+	selectExternalIDQuery = `
+		SELECT external_id
+		FROM pg2kafka.external_id_relations
+		WHERE table_name = $1::regclass::varchar
+	`
+
+	selectTableStatusQuery = `
+		SELECT
+			COALESCE(relations.table_name, backlog.table_name),
+			COALESCE(relations.external_id, ''),
+			relations.table_name IS NOT NULL,
+			EXISTS (
+				SELECT 1
+				FROM pg_trigger
+				WHERE tgrelid = to_regclass(relations.table_name::text)
+				AND tgfoid = 'pg2kafka.enqueue_event'::regproc
+				AND NOT tgisinternal
+			),
+			COALESCE(backlog.count, 0),
+			COALESCE(EXTRACT(EPOCH FROM current_timestamp::timestamp - backlog.oldest), 0)
+		FROM pg2kafka.external_id_relations AS relations
+		FULL OUTER JOIN (
+			SELECT table_name, count(*) AS count, min(created_at) AS oldest
+			FROM pg2kafka.outbound_event_queue
+			WHERE processed IS FALSE
+			GROUP BY table_name
+		) AS backlog ON backlog.table_name = relations.table_name
+		ORDER BY 1
+	`
+)
+
+// TableStatus describes a table that is tracked by pg2kafka, or that has
+// unprocessed events.
+type TableStatus struct {
+	Table string
+
+	// ExternalID is the column used as external ID, if the table is tracked.
+	ExternalID string
+
+	// Tracked is true when the table was set up, and not torn down since.
+	Tracked bool
+
+	// Trigger is true when the trigger enqueueing events for the table
+	// exists. A tracked table without trigger does not produce events.
+	Trigger bool
+
+	Backlog Backlog
+}
+
+// SetupTable starts tracking changes to a table, using the given column as
+// external ID. The current rows of the table are enqueued as snapshot events.
+// Setting up a table that is already tracked does nothing.
+func (eq *Queue) SetupTable(ctx context.Context, table, externalID string) error {
+	_, err := eq.db.ExecContext(ctx, setupTableQuery, table, externalID)
+	return errors.Wrapf(err, "error setting up table %s", table)
+}
+
+// TeardownTable stops tracking changes to a table. Events that were already
+// enqueued are still published.
+func (eq *Queue) TeardownTable(ctx context.Context, table string) error {
+	_, err := eq.db.ExecContext(ctx, teardownTableQuery, table)
+	return errors.Wrapf(err, "error tearing down table %s", table)
+}
+
+// SnapshotTable enqueues the current rows of a tracked table as snapshot
+// events, for example to bootstrap a new consumer.
+func (eq *Queue) SnapshotTable(ctx context.Context, table string) error {
+	externalID := ""
+	err := eq.db.QueryRowContext(ctx, selectExternalIDQuery, table).Scan(&externalID)
+	if err == sql.ErrNoRows {
+		return errors.Errorf("table %s is not set up", table)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "error looking up table %s", table)
+	}
+
+	_, err = eq.db.ExecContext(ctx, snapshotTableQuery, table)
+	return errors.Wrapf(err, "error snapshotting table %s", table)
+}
+
+// TableStatuses returns the status of every tracked table, and of the tables
+// that still have unprocessed events.
+func (eq *Queue) TableStatuses(ctx context.Context) ([]*TableStatus, error) {
+	ctx, cancel := eq.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := eq.db.QueryContext(ctx, selectTableStatusQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := []*TableStatus{}
+	for rows.Next() {
+		s := &TableStatus{}
+		age := 0.0
+		err = rows.Scan(&s.Table, &s.ExternalID, &s.Tracked, &s.Trigger, &s.Backlog.Count, &age)
+		if err != nil {
+			return nil, err
+		}
+		s.Backlog.OldestAge = time.Duration(age * float64(time.Second))
+		statuses = append(statuses, s)
+	}
+
+	if cerr := rows.Close(); cerr != nil {
+		return nil, cerr
+	}
+	return statuses, nil
+}
@@ -0,0 +1,19 @@
+module github.com/blendle/pg2kafka
+
+go 1.20
+
+require (
+	github.com/alicebob/miniredis/v2 v2.30.0
+	github.com/blendle/go-logger 2399adc0cd390782c11de7e2dd0bbcd5d4e7a110
+	github.com/buger/jsonparser v1.1.1
+	github.com/confluentinc/confluent-kafka-go v0.11.4
+	github.com/go-redis/redis/v8 v8.11.5
+	github.com/lib/pq v1.10.9
+	github.com/nats-io/nats-server/v2 v2.10.0
+	github.com/nats-io/nats.go v1.37.0
+	github.com/pkg/errors v0.9.1
+	github.com/prometheus/client_golang v1.20.5
+	go.uber.org/zap v1.27.0
+	google.golang.org/protobuf v1.34.2
+	gopkg.in/yaml.v2 v2.4.0
+)
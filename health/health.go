@@ -0,0 +1,90 @@
+// Package health implements the liveness and readiness endpoints of pg2kafka.
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/blendle/pg2kafka/eventqueue"
+	"github.com/pkg/errors"
+)
+
+// Check returns an error when the dependency it checks is unhealthy.
+type Check func() error
+
+// Handler serves the results of the given checks as JSON. It responds with
+// 200 OK if all checks pass, and 503 Service Unavailable otherwise.
+func Handler(checks map[string]Check) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		names := make([]string, 0, len(checks))
+		for name := range checks {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		status := http.StatusOK
+		results := map[string]string{}
+		for _, name := range names {
+			results[name] = "ok"
+			if err := checks[name](); err != nil {
+				status = http.StatusServiceUnavailable
+				results[name] = err.Error()
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"status": http.StatusText(status),
+			"checks": results,
+		})
+	})
+}
+
+// State tracks the health of a long-running component, such as the database
+// listener, which reports problems asynchronously.
+type State struct {
+	mu  sync.RWMutex
+	err error
+}
+
+// Set records the current health of the component, nil meaning healthy.
+func (s *State) Set(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.err = err
+}
+
+// Check returns the last error recorded by Set.
+func (s *State) Check() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.err
+}
+
+// Backlogger is implemented by queues that can report their backlog.
+type Backlogger interface {
+	UnprocessedEventsBacklog() (*eventqueue.Backlog, error)
+}
+
+// MaxBacklogAge returns a Check failing when the oldest unprocessed event is
+// older than max, which indicates that events are not being processed.
+func MaxBacklogAge(queue Backlogger, max time.Duration) Check {
+	return func() error {
+		backlog, err := queue.UnprocessedEventsBacklog()
+		if err != nil {
+			return errors.Wrap(err, "error fetching backlog")
+		}
+
+		if backlog.OldestAge > max {
+			return errors.Errorf("oldest unprocessed event is %v old, exceeding %v", backlog.OldestAge, max)
+		}
+
+		return nil
+	}
+}
@@ -0,0 +1,83 @@
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/blendle/pg2kafka/eventqueue"
+	"github.com/pkg/errors"
+)
+
+func TestHandler(t *testing.T) {
+	listener := &State{}
+
+	handler := Handler(map[string]Check{
+		"database": func() error { return nil },
+		"listener": listener.Check,
+	})
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", rec.Code)
+	}
+
+	listener.Set(errors.New("disconnected"))
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/healthz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503, got %d", rec.Code)
+	}
+
+	body := struct {
+		Checks map[string]string `json:"checks"`
+	}{}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+
+	if body.Checks["database"] != "ok" {
+		t.Errorf("Expected database check to be 'ok', got %q", body.Checks["database"])
+	}
+
+	if body.Checks["listener"] != "disconnected" {
+		t.Errorf("Expected listener check to be 'disconnected', got %q", body.Checks["listener"])
+	}
+}
+
+type mockBacklogger struct {
+	backlog *eventqueue.Backlog
+	err     error
+}
+
+func (b *mockBacklogger) UnprocessedEventsBacklog() (*eventqueue.Backlog, error) {
+	return b.backlog, b.err
+}
+
+var maxBacklogAgeTests = []struct {
+	name    string
+	backlog *eventqueue.Backlog
+	err     error
+	healthy bool
+}{
+	{"empty", &eventqueue.Backlog{}, nil, true},
+	{"recent", &eventqueue.Backlog{Count: 10, OldestAge: time.Second}, nil, true},
+	{"stale", &eventqueue.Backlog{Count: 10, OldestAge: time.Hour}, nil, false},
+	{"error", nil, errors.New("connection refused"), false},
+}
+
+func TestMaxBacklogAge(t *testing.T) {
+	for _, tt := range maxBacklogAgeTests {
+		t.Run(tt.name, func(t *testing.T) {
+			check := MaxBacklogAge(&mockBacklogger{backlog: tt.backlog, err: tt.err}, time.Minute)
+
+			if err := check(); (err == nil) != tt.healthy {
+				t.Errorf("Expected healthy to be %v, got error %v", tt.healthy, err)
+			}
+		})
+	}
+}
@@ -1,35 +1,65 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"net/http"
 	"net/url"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	logger "github.com/blendle/go-logger"
+	"github.com/blendle/pg2kafka/config"
 	"github.com/blendle/pg2kafka/eventqueue"
+	"github.com/blendle/pg2kafka/health"
+	"github.com/blendle/pg2kafka/metrics"
+	"github.com/blendle/pg2kafka/protobuf"
+	"github.com/blendle/pg2kafka/sink"
 	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/go-redis/redis/v8"
 	"github.com/lib/pq"
 	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.uber.org/zap"
 )
 
 var (
 	topicNamespace string
+	databaseName   string
 	version        string
-)
 
-// Producer is the minimal required interface pg2kafka requires to produce
-// events to a kafka topic.
-type Producer interface {
-	Close()
-	Flush(int) int
+	// tables holds the configuration of how the events of each table are
+	// published.
+	tables config.Tables
 
-	Produce(*kafka.Message, chan kafka.Event) error
-}
+	// coalesce merges the events of the same row before publishing them.
+	coalesce bool
+
+	// sinkKind is the kind of sink events are published to, see setupSink.
+	sinkKind string
+
+	// schemaChangesTopic is the topic schema change events are published to.
+	schemaChangesTopic string
+
+	// schemas caches the schemas of tables configured to include them in
+	// their events.
+	schemas *eventqueue.SchemaCache
+
+	// protobufEncoder encodes the events of tables using the protobuf format.
+	protobufEncoder *protobuf.Encoder
+
+	// listenerState tracks whether the database listener is connected.
+	listenerState = &health.State{}
+
+	// pollAfter waits for the next poll of the queue, see pollQueue.
+	pollAfter = time.After
+)
 
 func main() {
 	conf := &logger.Config{
@@ -42,165 +72,911 @@ func main() {
 
 	logger.Init(conf)
 
-	conninfo := os.Getenv("DATABASE_URL")
-	topicNamespace = parseTopicNamespace(os.Getenv("TOPIC_NAMESPACE"), parseDatabaseName(conninfo))
+	// Commands return instead of exiting on errors, so their deferred cleanup,
+	// such as flushing the sink, always happens.
+	if err := execute(os.Args[1:]); err != nil {
+		logger.L.Fatal("pg2kafka stopped", zap.Error(err))
+	}
+}
 
-	eq, err := eventqueue.New(conninfo)
+func run() error {
+	cfg, err := config.Load(os.Getenv("CONFIG_FILE"))
 	if err != nil {
-		logger.L.Fatal("Error opening db connection", zap.Error(err))
+		return err
 	}
+
+	// ctx is cancelled when pg2kafka exits, cancelling any running queries.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	eq, err := eventqueue.New(cfg.DatabaseURL)
+	if err != nil {
+		return errors.Wrap(err, "error opening db connection")
+	}
+	eq.SetQueryTimeout(cfg.QueryTimeout)
+	eq.SetBatchSize(cfg.BatchSize, cfg.MaxBatchBytes)
 	defer func() {
 		if cerr := eq.Close(); cerr != nil {
-			logger.L.Fatal("Error closing db connection", zap.Error(cerr))
+			logger.L.Error("Error closing db connection", zap.Error(cerr))
 		}
 	}()
+	configurePublishing(cfg, eq)
 
-	if os.Getenv("PERFORM_MIGRATIONS") == "true" {
-		if cerr := eq.ConfigureOutboundEventQueueAndTriggers("./sql"); cerr != nil {
-			logger.L.Fatal("Error configuring outbound_event_queue and triggers", zap.Error(cerr))
+	if cfg.PerformMigrations {
+		if merr := migrate(ctx, cfg, eq); merr != nil {
+			return merr
 		}
 	} else {
 		logger.L.Info("Not performing database migrations due to missing `PERFORM_MIGRATIONS`.")
 	}
 
-	producer := setupProducer()
-	defer producer.Close()
-	defer producer.Flush(1000)
-
-	reportProblem := func(ev pq.ListenerEventType, err error) {
-		if err != nil {
-			logger.L.Error("Error handling postgres notify", zap.Error(err))
+	if cfg.SchemaChanges.Enabled {
+		if serr := eq.EnableSchemaEvents(ctx); serr != nil {
+			return serr
 		}
 	}
-	listener := pq.NewListener(conninfo, 10*time.Second, time.Minute, reportProblem)
-	if err := listener.Listen("outbound_event_queue"); err != nil {
-		logger.L.Error("Error listening to pg", zap.Error(err))
+
+	s, err := setupSink(cfg.Sink, cfg.Tables)
+	if err != nil {
+		return err
 	}
 	defer func() {
-		if cerr := listener.Close(); cerr != nil {
-			logger.L.Error("Error closing listener", zap.Error(cerr))
+		if cerr := s.Close(); cerr != nil {
+			logger.L.Error("Error closing sink", zap.Error(cerr))
 		}
 	}()
 
-	// Process any events left in the queue
-	processQueue(producer, eq)
+	var listener *pq.Listener
+	if !cfg.PollOnly {
+		listener = setupListener(cfg.DirectURL())
+		defer func() {
+			if cerr := listener.Close(); cerr != nil {
+				logger.L.Error("Error closing listener", zap.Error(cerr))
+			}
+		}()
+	}
+
+	prometheus.MustRegister(metrics.NewBacklogCollector(eq))
+	liveness, readiness := healthChecks(eq, s, cfg.Health)
+	if cfg.PollOnly {
+		delete(liveness, "listener")
+		delete(readiness, "listener")
+	}
+	srv := serveHTTP(cfg.HTTPAddr, liveness, readiness)
+	defer func() {
+		if cerr := srv.Close(); cerr != nil {
+			logger.L.Error("Error closing HTTP server", zap.Error(cerr))
+		}
+	}()
 
 	signals := make(chan os.Signal, 1)
-	signal.Notify(signals, os.Interrupt)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go checkDrift(ctx, eq, cfg.Drift)
+
+	stop := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		// Process any events left in the queue
+		if _, perr := processQueue(ctx, s, eq, stop); perr != nil {
+			done <- perr
+			return
+		}
+
+		drain := func() (int, error) {
+			return processQueue(ctx, s, eq, stop)
+		}
+		if cfg.PollOnly {
+			logger.L.Info("pg2kafka is now polling for events")
+			done <- pollQueue(cfg.MinPollInterval, cfg.PollInterval, stop, drain)
+			return
+		}
+
+		logger.L.Info("pg2kafka is now listening to notifications")
+		done <- waitForNotification(listener, cfg.PollInterval, stop, func() error {
+			_, derr := drain()
+			return derr
+		})
+	}()
 
-	logger.L.Info("pg2kafka is now listening to notifications")
-	waitForNotification(listener, producer, eq, signals)
+	select {
+	case err = <-done:
+		return err
+	case sig := <-signals:
+		logger.L.Info("Shutting down, waiting for in-flight events", zap.Stringer("signal", sig))
+	}
+
+	// Stop fetching new events, and give the events that are being published
+	// some time to be delivered and marked as processed.
+	close(stop)
+	select {
+	case err = <-done:
+		return err
+	case <-time.After(cfg.ShutdownTimeout):
+		// Events that were not marked as processed yet are published again
+		// after a restart, so this is not an error.
+		logger.L.Warn(
+			"Timed out waiting for in-flight events, cancelling them",
+			zap.Duration("timeout", cfg.ShutdownTimeout),
+		)
+
+		// Cancel the queries of the events still being processed, and wait for
+		// them to return before the deferred calls close the connections.
+		cancel()
+		<-done
+		return nil
+	}
 }
 
-// ProcessEvents queries the database for unprocessed events and produces them
-// to kafka.
-func ProcessEvents(p Producer, eq *eventqueue.Queue) {
-	events, err := eq.FetchUnprocessedRecords()
+// migrate applies the pending migrations. They take a session-level advisory
+// lock, so they connect directly when a pooler sits in front of the database.
+func migrate(ctx context.Context, cfg *config.Config, eq *eventqueue.Queue) error {
+	if cfg.DirectURL() != cfg.DatabaseURL {
+		direct, err := eventqueue.New(cfg.DirectURL())
+		if err != nil {
+			return errors.Wrap(err, "error opening direct db connection")
+		}
+		defer direct.Close() // nolint: errcheck
+		eq = direct
+	}
+
+	migrations, err := eq.Migrate(ctx)
 	if err != nil {
+		return errors.Wrap(err, "error migrating the pg2kafka schema")
+	}
+	for _, m := range migrations {
+		logger.L.Info("Applied migration", zap.Int("version", m.Version), zap.String("name", m.Name))
+	}
+
+	return nil
+}
+
+// setupListener listens for notifications of new events, keeping track of
+// the health of the listener.
+func setupListener(conninfo string) *pq.Listener {
+	reportProblem := func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			logger.L.Error("Error handling postgres notify", zap.Error(err))
+		}
+
+		switch ev {
+		case pq.ListenerEventConnected, pq.ListenerEventReconnected:
+			listenerState.Set(nil)
+		case pq.ListenerEventDisconnected, pq.ListenerEventConnectionAttemptFailed:
+			if err == nil {
+				err = errors.New("listener disconnected")
+			}
+			listenerState.Set(err)
+		}
+	}
+
+	listener := pq.NewListener(conninfo, 10*time.Second, time.Minute, reportProblem)
+	if err := listener.Listen("outbound_event_queue"); err != nil {
 		logger.L.Error("Error listening to pg", zap.Error(err))
+		listenerState.Set(err)
 	}
 
-	produceMessages(p, events, eq)
+	return listener
 }
 
-func processQueue(p Producer, eq *eventqueue.Queue) {
-	pageCount, err := eq.UnprocessedEventPagesCount()
+// configurePublishing sets the configuration used to turn events into
+// messages.
+func configurePublishing(cfg *config.Config, eq *eventqueue.Queue) {
+	schemas = eventqueue.NewSchemaCache(eq.TableSchema, cfg.SchemaCacheTTL)
+	protobufEncoder = protobuf.NewEncoder(cfg.Protobuf.DescriptorSetFile)
+	databaseName = parseDatabaseName(cfg.DatabaseURL)
+	topicNamespace = parseTopicNamespace(cfg.TopicNamespace, databaseName)
+	tables = cfg.Tables
+	coalesce = cfg.Coalesce
+	sinkKind = cfg.Sink.Type
+
+	schemaChangesTopic = cfg.SchemaChanges.Topic
+	if schemaChangesTopic == "" {
+		schemaChangesTopic = fmt.Sprintf("pg2kafka.%v.schema_changes", topicNamespace)
+	}
+}
+
+// ProcessEvents queries the database for the first batch of unprocessed events
+// and publishes them to the sink.
+func ProcessEvents(ctx context.Context, s sink.Sink, eq *eventqueue.Queue) error {
+	_, err := processBatch(ctx, s, eq, 0)
+	return err
+}
+
+// processBatch publishes the batch of unprocessed events after the given ID,
+// and returns the ID of its last event, or zero if there were none.
+func processBatch(ctx context.Context, s sink.Sink, eq *eventqueue.Queue, afterID int) (int, error) {
+	events, err := eq.FetchUnprocessedRecordsAfterContext(ctx, afterID)
 	if err != nil {
-		logger.L.Fatal("Error selecting count", zap.Error(err))
+		return 0, errors.Wrap(err, "error fetching unprocessed events")
+	}
+	if len(events) == 0 {
+		return 0, nil
 	}
 
-	for i := 0; i <= pageCount; i++ {
-		ProcessEvents(p, eq)
+	for _, event := range events {
+		metrics.EventsFetched.WithLabelValues(event.TableName, event.Statement).Inc()
+	}
+
+	return events[len(events)-1].ID, produceMessages(ctx, s, events, eq)
+}
+
+// processQueue processes batches of unprocessed events until there are none
+// left, including events enqueued while processing, or stop is closed. It
+// returns how many batches it processed.
+func processQueue(ctx context.Context, s sink.Sink, eq *eventqueue.Queue, stop <-chan struct{}) (int, error) {
+	lastID := 0
+	for batches := 0; ; batches++ {
+		select {
+		case <-stop:
+			return batches, nil
+		default:
+		}
+
+		id, err := processBatch(ctx, s, eq, lastID)
+		if err != nil || id == 0 {
+			return batches, err
+		}
+		lastID = id
 	}
 }
 
+// notifier is implemented by *pq.Listener.
+type notifier interface {
+	NotificationChannel() <-chan *pq.Notification
+	Ping() error
+}
+
+// waitForNotification drains the queue whenever a notification arrives, until
+// stop is closed. The listener sends a nil notification after reconnecting,
+// as notifications may have been missed while it was disconnected, and the
+// queue is drained every poll interval for notifications missed otherwise.
 func waitForNotification(
-	l *pq.Listener,
-	p Producer,
-	eq *eventqueue.Queue,
-	signals chan os.Signal,
-) {
+	l notifier,
+	pollInterval time.Duration,
+	stop <-chan struct{},
+	drain func() error,
+) error {
+	ping := time.NewTicker(90 * time.Second)
+	defer ping.Stop()
+
+	var poll <-chan time.Time
+	if pollInterval > 0 {
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+		poll = ticker.C
+	}
+
 	for {
+		trigger := "notification"
 		select {
-		case <-l.Notify:
-			processQueue(p, eq)
-		case <-time.After(90 * time.Second):
+		case n, ok := <-l.NotificationChannel():
+			if !ok {
+				return errors.New("listener closed")
+			}
+			if n == nil {
+				logger.L.Info("Listener reconnected, processing events that may have been missed")
+				trigger = "reconnect"
+			}
+		case <-poll:
+			trigger = "poll"
+		case <-ping.C:
 			go func() {
 				err := l.Ping()
 				if err != nil {
-					logger.L.Fatal("Error pinging listener", zap.Error(err))
+					logger.L.Error("Error pinging listener", zap.Error(err))
 				}
+				listenerState.Set(err)
 			}()
-		case <-signals:
+			continue
+		case <-stop:
+			return nil
+		}
+
+		metrics.QueueDrains.WithLabelValues(trigger).Inc()
+		if err := drain(); err != nil {
+			return err
+		}
+	}
+}
+
+// pollQueue drains the queue without relying on notifications, until stop is
+// closed. It polls every minInterval while the queue had events, doubling the
+// interval up to maxInterval while it had none.
+func pollQueue(minInterval, maxInterval time.Duration, stop <-chan struct{}, drain func() (int, error)) error {
+	interval := minInterval
+	for {
+		select {
+		case <-pollAfter(interval):
+		case <-stop:
+			return nil
+		}
+
+		metrics.QueueDrains.WithLabelValues("poll").Inc()
+		batches, err := drain()
+		if err != nil {
+			return err
+		}
+
+		interval *= 2
+		if batches > 0 {
+			interval = minInterval
+		} else if interval > maxInterval {
+			interval = maxInterval
+		}
+	}
+}
+
+// checkDrift verifies the tracked tables every interval until ctx is done.
+func checkDrift(ctx context.Context, eq *eventqueue.Queue, c config.DriftConfig) {
+	ticker := time.NewTicker(c.Interval)
+	defer ticker.Stop()
+
+	for {
+		verifyTables(ctx, eq, c.Repair)
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// verifyTables logs the drift of the tracked tables and exposes it as metric,
+// after repairing what it can if repair is true.
+func verifyTables(ctx context.Context, eq *eventqueue.Queue, repair bool) {
+	drift, err := eq.Verify(ctx)
+	if err != nil {
+		logger.L.Error("Error checking tables for drift", zap.Error(err))
+		return
+	}
+
+	if repair && len(drift) > 0 {
+		repaired, rerr := eq.Repair(ctx, drift)
+		for _, d := range repaired {
+			logger.L.Warn(
+				"Reinstalled missing trigger, snapshot the table to recover changes made without it",
+				zap.String("table", d.Table),
+			)
+		}
+		if rerr != nil {
+			logger.L.Error("Error repairing drift", zap.Error(rerr))
+		}
+
+		if drift, err = eq.Verify(ctx); err != nil {
+			logger.L.Error("Error checking tables for drift", zap.Error(err))
 			return
 		}
 	}
+
+	metrics.TableDrift.Reset()
+	for _, d := range drift {
+		logger.L.Warn(
+			"Tracked table drifted",
+			zap.String("table", d.Table),
+			zap.String("problem", d.Problem),
+			zap.String("detail", d.Detail),
+		)
+		metrics.TableDrift.WithLabelValues(d.Table, d.Problem).Set(1)
+	}
 }
 
-func produceMessages(p Producer, events []*eventqueue.Event, eq *eventqueue.Queue) {
-	deliveryChan := make(chan kafka.Event)
+func produceMessages(
+	ctx context.Context,
+	s sink.Sink,
+	events []*eventqueue.Event,
+	eq *eventqueue.Queue,
+) error {
+	published := make([]*eventqueue.Event, 0, len(events))
 	for _, event := range events {
-		msg, err := json.Marshal(event)
+		if !tables.Get(event.TableName).Filters.Match(event.Statement) {
+			if err := eq.MarkEventAsProcessedContext(ctx, event.ID); err != nil {
+				return errors.Wrap(err, "error marking filtered record as processed")
+			}
+			continue
+		}
+		published = append(published, event)
+	}
+
+	if coalesce {
+		var err error
+		if published, err = coalesceEvents(published); err != nil {
+			return err
+		}
+	}
+
+	msgs := make([]*sink.Message, 0, len(published))
+	for _, event := range published {
+		table := tables.Get(event.TableName)
+		if err := describeColumns(ctx, event, table); err != nil {
+			return err
+		}
+
+		msg, err := newMessage(event, table)
 		if err != nil {
-			logger.L.Fatal("Error parsing event", zap.Error(err))
-		}
-
-		topic := topicName(event.TableName)
-		message := &kafka.Message{
-			TopicPartition: kafka.TopicPartition{
-				Topic:     &topic,
-				Partition: kafka.PartitionAny, // nolint: gotype
-			},
-			Value:     msg,
-			Key:       event.ExternalID,
-			Timestamp: event.CreatedAt,
-		}
-		if os.Getenv("DRY_RUN") != "" {
-			logger.L.Info("Would produce message", zap.Any("message", message))
-		} else {
-			err = p.Produce(message, deliveryChan)
+			return err
+		}
+		msgs = append(msgs, msg)
+	}
+
+	start := time.Now()
+	delivered, err := s.Publish(ctx, msgs)
+	metrics.DeliveryDuration.WithLabelValues(sinkKind).Observe(time.Since(start).Seconds())
+
+	// Mark whatever was delivered before a failure, so it is not published a
+	// second time after a restart.
+	for _, event := range published[:delivered] {
+		metrics.EventsProduced.WithLabelValues(event.TableName, event.Statement).Inc()
+		metrics.EventAge.WithLabelValues(event.TableName).Observe(time.Since(event.CreatedAt).Seconds())
+
+		for _, id := range append(event.Coalesced, event.ID) {
+			if merr := eq.MarkEventAsProcessedContext(ctx, id); merr != nil {
+				return errors.Wrap(merr, "error marking record as processed")
+			}
+		}
+	}
+
+	if err != nil {
+		for _, event := range published[delivered:] {
+			metrics.EventsFailed.WithLabelValues(event.TableName, event.Statement).Inc()
+		}
+		return errors.Wrap(err, "failed to publish")
+	}
+
+	return nil
+}
+
+// coalesceEvents merges the events of the same row, and reports how many
+// events were folded into others.
+func coalesceEvents(events []*eventqueue.Event) ([]*eventqueue.Event, error) {
+	coalesced, err := eventqueue.Coalesce(events)
+	if err != nil {
+		return nil, errors.Wrap(err, "error coalescing events")
+	}
+
+	folded := 0
+	for _, event := range coalesced {
+		folded += len(event.Coalesced)
+		metrics.EventsCoalesced.WithLabelValues(event.TableName).Add(float64(len(event.Coalesced)))
+	}
+	if folded > 0 {
+		logger.L.Info("Coalesced events", zap.Int("fetched", len(events)), zap.Int("folded", folded))
+	}
+
+	return coalesced, nil
+}
+
+// replayEvents publishes the processed events selected by opts again, to the
+// given topic instead of their own if it is not empty. Their processed state
+// is left alone, and they are not counted as produced in the metrics.
+func replayEvents(
+	ctx context.Context,
+	s sink.Sink,
+	eq *eventqueue.Queue,
+	opts eventqueue.ReplayOptions,
+	topic string,
+) (int, error) {
+	return eq.Replay(ctx, opts, func(events []*eventqueue.Event) error {
+		msgs := make([]*sink.Message, 0, len(events))
+		for _, event := range events {
+			table := tables.Get(event.TableName)
+			if !table.Filters.Match(event.Statement) {
+				continue
+			}
+
+			if err := describeColumns(ctx, event, table); err != nil {
+				return err
+			}
+
+			msg, err := newMessage(event, table)
 			if err != nil {
-				logger.L.Fatal("Failed to produce", zap.Error(err))
+				return err
 			}
-			e := <-deliveryChan
+			if topic != "" {
+				msg.Topic = topic
+			}
+			msgs = append(msgs, msg)
+		}
+
+		delivered, err := s.Publish(ctx, msgs)
+		if err != nil {
+			return errors.Wrapf(err, "failed to replay, %d of %d events in batch delivered", delivered, len(msgs))
+		}
+
+		return nil
+	})
+}
+
+// newMessage encodes an event into a message, as configured for its table.
+// Schema change events are published to the schema changes topic instead,
+// keyed by table, with the transforms applied to the columns they describe.
+func newMessage(event *eventqueue.Event, table *config.TableConfig) (*sink.Message, error) {
+	msg := &sink.Message{
+		ID:        event.UUID,
+		Table:     event.TableName,
+		Statement: event.Statement,
+		Topic:     topicName(event.TableName),
+		Key:       messageKey(event, table.Key),
+		Timestamp: event.CreatedAt,
+	}
+
+	contentType := "application/json"
+	var headers []sink.Header
+	var err error
+	switch {
+	case event.Statement == eventqueue.StatementSchema:
+		msg.Topic = schemaChangesTopic
+		msg.Key = []byte(event.TableName)
+		msg.Value, err = encodeSchemaChange(event, table)
+	case table.Format == config.FormatProtobuf:
+		contentType = protobuf.ContentType
+		msg.Value, err = encodeProtobuf(event, table)
+	case table.Format == config.FormatCloudEvents:
+		contentType = cloudEventsContentType
+		msg.Value, _, err = encodeCloudEvent(event, table)
+	case table.Format == config.FormatCloudEventsBinary:
+		msg.Value, headers, err = encodeCloudEvent(event, table)
+	default:
+		msg.Value, err = encodeJSON(event, table)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "error encoding event %s", event.UUID)
+	}
+
+	// CloudEvents in binary mode need the content type and their attributes
+	// as headers, even if the table does not include the others.
+	switch {
+	case table.Headers:
+		msg.Headers = append(messageHeaders(event, contentType), headers...)
+	case headers != nil:
+		msg.Headers = append([]sink.Header{{Key: "content-type", Value: []byte(contentType)}}, headers...)
+	}
+
+	return msg, nil
+}
 
-			result := e.(*kafka.Message)
-			if result.TopicPartition.Error != nil {
-				logger.L.Fatal("Delivery failed", zap.Error(result.TopicPartition.Error))
+// encodeSchemaChange encodes a schema change event as JSON, describing the
+// columns as they are published after applying the transforms of the table.
+func encodeSchemaChange(event *eventqueue.Event, table *config.TableConfig) ([]byte, error) {
+	schema := &eventqueue.TableSchema{}
+	if err := json.Unmarshal(event.Data, schema); err != nil {
+		return nil, errors.Wrap(err, "error parsing schema change")
+	}
+
+	data, err := json.Marshal(transformSchema(schema, table.Transforms))
+	if err != nil {
+		return nil, err
+	}
+
+	transformed := *event
+	transformed.Data = data
+	return json.Marshal(&transformed)
+}
+
+func encodeJSON(event *eventqueue.Event, table *config.TableConfig) ([]byte, error) {
+	data, err := transformData(event, table)
+	if err != nil {
+		return nil, err
+	}
+
+	transformed := *event
+	transformed.Data = data
+	transformed.TableSchema = nil
+	if table.Schema {
+		transformed.TableSchema = transformSchema(event.TableSchema, table.Transforms)
+	}
+
+	return json.Marshal(&transformed)
+}
+
+func encodeProtobuf(event *eventqueue.Event, table *config.TableConfig) ([]byte, error) {
+	data, err := transformData(event, table)
+	if err != nil {
+		return nil, err
+	}
+
+	transformed := *event
+	transformed.Data = data
+	return protobufEncoder.Encode(&transformed, transformSchema(event.TableSchema, table.Transforms))
+}
+
+// transformData applies the transforms and number encoding configured for a
+// table to the data of an event.
+func transformData(event *eventqueue.Event, table *config.TableConfig) (json.RawMessage, error) {
+	data := event.Data
+	if table.LosslessNumbers {
+		var err error
+		if data, err = losslessNumbers(data, event.TableSchema); err != nil {
+			return nil, errors.Wrap(err, "error encoding numbers")
+		}
+	}
+
+	data, err := table.Transforms.Apply(data)
+	return data, errors.Wrap(err, "error transforming data")
+}
+
+// describeColumns adds the schema of the event's table to the event, if the
+// table is configured to include it or needs it for encoding. Schema change
+// events update the cached schema instead. Events of tables that were dropped
+// or renamed since, and were never described, are published without schema.
+func describeColumns(ctx context.Context, event *eventqueue.Event, table *config.TableConfig) error {
+	if event.Statement == eventqueue.StatementSchema {
+		return schemas.Update(event)
+	}
+	if !table.Schema && !table.LosslessNumbers && table.Format != config.FormatProtobuf {
+		return nil
+	}
+
+	schema, err := schemas.Get(ctx, event)
+	if errors.Cause(err) == eventqueue.ErrTableNotFound {
+		logger.L.Warn("Table no longer exists, publishing event without its schema",
+			zap.String("table", event.TableName), zap.String("uuid", event.UUID))
+		metrics.EventsWithoutSchema.WithLabelValues(event.TableName).Inc()
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	event.TableSchema = schema
+	return nil
+}
+
+// transformSchema applies the transforms of a table to its schema, so it
+// describes the transformed data.
+func transformSchema(schema *eventqueue.TableSchema, t config.Transforms) *eventqueue.TableSchema {
+	if schema == nil || (len(t.Exclude) == 0 && len(t.Rename) == 0) {
+		return schema
+	}
+
+	excluded := map[string]bool{}
+	for _, column := range t.Exclude {
+		excluded[column] = true
+	}
+
+	transformed := &eventqueue.TableSchema{Columns: []*eventqueue.Column{}}
+	for _, c := range schema.Columns {
+		if excluded[c.Name] {
+			continue
+		}
+
+		column := *c
+		if to, ok := t.Rename[c.Name]; ok {
+			column.Name = to
+		}
+		transformed.Columns = append(transformed.Columns, &column)
+	}
+
+	return transformed
+}
+
+// losslessNumbers encodes the values of numeric and bigint columns, and of
+// arrays of them, as strings, so JSON parsers do not turn them into floats.
+func losslessNumbers(data json.RawMessage, schema *eventqueue.TableSchema) (json.RawMessage, error) {
+	if schema == nil {
+		return data, nil
+	}
+
+	columns := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &columns); err != nil {
+		return nil, errors.Wrap(err, "error parsing event data")
+	}
+
+	for name, value := range columns {
+		c := schema.Column(name)
+		if c == nil || !(strings.HasPrefix(c.Type, "numeric") || strings.HasPrefix(c.Type, "bigint")) {
+			continue
+		}
+
+		v, err := numbersToStrings(value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error encoding column %s", name)
+		}
+		columns[name] = v
+	}
+
+	return json.Marshal(columns)
+}
+
+// numbersToStrings quotes a JSON number, or the numbers in a JSON array.
+func numbersToStrings(value json.RawMessage) (json.RawMessage, error) {
+	switch {
+	case len(value) == 0:
+		return value, nil
+	case value[0] == '[':
+		values := []json.RawMessage{}
+		if err := json.Unmarshal(value, &values); err != nil {
+			return nil, err
+		}
+		for i, v := range values {
+			s, err := numbersToStrings(v)
+			if err != nil {
+				return nil, err
 			}
+			values[i] = s
 		}
-		err = eq.MarkEventAsProcessed(event.ID)
+		return json.Marshal(values)
+	case value[0] == '-' || (value[0] >= '0' && value[0] <= '9'):
+		return json.RawMessage(strconv.Quote(string(value))), nil
+	default:
+		return value, nil
+	}
+}
+
+// messageKey returns the value of the given column as message key, falling
+// back to the external ID when no column is given or the event data does not
+// contain it.
+func messageKey(event *eventqueue.Event, column string) []byte {
+	if column == "" {
+		return event.ExternalID
+	}
+
+	columns := map[string]json.RawMessage{}
+	if err := json.Unmarshal(event.Data, &columns); err != nil {
+		return event.ExternalID
+	}
+
+	value, ok := columns[column]
+	if !ok || string(value) == "null" {
+		return event.ExternalID
+	}
+
+	var s string
+	if err := json.Unmarshal(value, &s); err == nil {
+		return []byte(s)
+	}
+
+	return value
+}
+
+// setupSink creates the sink of the configured type.
+func setupSink(c config.SinkConfig, tables config.Tables) (sink.Sink, error) {
+	switch c.Type {
+	case config.SinkKafka:
+		p, err := setupProducer(c.Kafka)
 		if err != nil {
-			logger.L.Fatal("Error marking record as processed", zap.Error(err))
+			return nil, err
 		}
+		return sink.NewKafka(p), nil
+	case config.SinkStdout:
+		return sink.NewStdout(), nil
+	case config.SinkFile:
+		s, err := sink.OpenFile(c.File.Path)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to setup file sink")
+		}
+		return s, nil
+	case config.SinkNATS:
+		s, err := sink.NewNATS(c.NATS.URL)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to setup nats sink")
+		}
+		return s, nil
+	case config.SinkRedis:
+		opts, err := redis.ParseURL(c.Redis.URL)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse redis url")
+		}
+		return sink.NewRedis(redis.NewClient(opts), c.Redis.MaxLen), nil
+	case config.SinkWebhook:
+		tableURLs := map[string]string{}
+		for name, table := range tables {
+			if table.WebhookURL != "" && name != config.AllTables {
+				tableURLs[name] = table.WebhookURL
+			}
+		}
+		webhookURL := c.Webhook.URL
+		if webhookURL == "" {
+			webhookURL = tables.Get(config.AllTables).WebhookURL
+		}
+		return sink.NewWebhook(sink.WebhookConfig{
+			URL:        webhookURL,
+			TableURLs:  tableURLs,
+			Secret:     []byte(c.Webhook.Secret),
+			MaxRetries: *c.Webhook.MaxRetries,
+			Backoff:    c.Webhook.Backoff,
+		}), nil
+	default:
+		return nil, errors.Errorf("unknown sink %q", c.Type)
 	}
 }
 
-func setupProducer() Producer {
-	broker := os.Getenv("KAFKA_BROKER")
-	if broker == "" {
-		panic("missing KAFKA_BROKER environment")
+// healthChecks returns the liveness and readiness checks. Liveness only fails
+// on problems a restart may resolve, such as a broken listener or events no
+// longer being processed, while readiness also checks external dependencies.
+func healthChecks(
+	eq *eventqueue.Queue,
+	s sink.Sink,
+	c config.HealthConfig,
+) (liveness, readiness map[string]health.Check) {
+	liveness = map[string]health.Check{
+		"listener": listenerState.Check,
+	}
+	readiness = map[string]health.Check{
+		"database": eq.Ping,
+		"listener": listenerState.Check,
+	}
+
+	if p, ok := s.(sink.Pinger); ok {
+		readiness["sink"] = p.Ping
+	}
+
+	if c.MaxBacklogAge > 0 {
+		liveness["backlog"] = health.MaxBacklogAge(eq, c.MaxBacklogAge)
 	}
 
+	return liveness, readiness
+}
+
+// serveHTTP serves the metrics and health endpoints on the given address in
+// the background.
+func serveHTTP(addr string, liveness, readiness map[string]health.Check) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.Handle("/healthz", health.Handler(liveness))
+	mux.Handle("/readyz", health.Handler(readiness))
+
+	srv := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		logger.L.Info("Serving HTTP", zap.String("addr", addr))
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.L.Error("Error serving HTTP", zap.Error(err))
+		}
+	}()
+
+	return srv
+}
+
+func setupProducer(c config.KafkaConfig) (sink.Producer, error) {
 	hostname, err := os.Hostname()
 	if err != nil {
 		hostname = os.Getenv("HOSTNAME")
 	}
 
-	p, err := kafka.NewProducer(&kafka.ConfigMap{
-		"client.id":         hostname,
-		"bootstrap.servers": broker,
+	p, err := kafka.NewProducer(producerConfig(c, hostname))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to setup producer")
+	}
+
+	return p, nil
+}
+
+// producerConfig builds the librdkafka configuration of the producer. The
+// configured properties take precedence over the defaults.
+func producerConfig(c config.KafkaConfig, clientID string) *kafka.ConfigMap {
+	cm := &kafka.ConfigMap{
+		"client.id":         clientID,
+		"bootstrap.servers": c.Broker,
 		"partitioner":       "murmur2",
 		"compression.codec": "snappy",
-	})
-	if err != nil {
-		panic(errors.Wrap(err, "failed to setup producer"))
 	}
+	for key, value := range c.Properties {
+		(*cm)[key] = value
+	}
+
+	return cm
+}
 
-	return p
+// messageHeaders returns the headers describing the given event, so consumers
+// can route and filter messages without parsing their payload.
+func messageHeaders(event *eventqueue.Event, contentType string) []sink.Header {
+	return []sink.Header{
+		{Key: "content-type", Value: []byte(contentType)},
+		{Key: "pg2kafka.version", Value: []byte(version)},
+		{Key: "pg2kafka.uuid", Value: []byte(event.UUID)},
+		{Key: "pg2kafka.statement", Value: []byte(event.Statement)},
+		{Key: "pg2kafka.schema", Value: []byte(event.Schema)},
+		{Key: "pg2kafka.table", Value: []byte(event.TableName)},
+		{Key: "pg2kafka.txid", Value: []byte(strconv.FormatInt(event.TxID, 10))},
+	}
 }
 
 func topicName(tableName string) string {
+	if topic := tables.Get(tableName).Topic; topic != "" {
+		return topic
+	}
+
 	return fmt.Sprintf("pg2kafka.%v.%v", topicNamespace, tableName)
 }
 
@@ -1,15 +1,23 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"os"
+	"reflect"
 	"testing"
+	"time"
 
 	"github.com/buger/jsonparser"
-	"github.com/confluentinc/confluent-kafka-go/kafka"
 
+	"github.com/blendle/pg2kafka/config"
 	"github.com/blendle/pg2kafka/eventqueue"
-	_ "github.com/lib/pq"
+	"github.com/blendle/pg2kafka/protobuf"
+	"github.com/blendle/pg2kafka/sink"
+	"github.com/lib/pq"
 )
 
 func TestFetchUnprocessedRecords(t *testing.T) {
@@ -54,19 +62,19 @@ func TestFetchUnprocessedRecords(t *testing.T) {
 		t.Fatalf("Error inserting events: %v", err)
 	}
 
-	p := &mockProducer{
-		messages: make([]*kafka.Message, 0),
-	}
+	s := &mockSink{}
 
-	ProcessEvents(p, eq)
+	if err := ProcessEvents(context.Background(), s, eq); err != nil {
+		t.Fatal(err)
+	}
 
 	expected := 4
-	actual := len(p.messages)
+	actual := len(s.messages)
 	if actual != expected {
 		t.Fatalf("Unexpected number of messages produced. Expected %d, got %d", expected, actual)
 	}
 
-	msg := p.messages[0]
+	msg := s.messages[0]
 	email, err := jsonparser.GetString(msg.Value, "data", "email")
 	if err != nil {
 		t.Fatal(err)
@@ -85,7 +93,7 @@ func TestFetchUnprocessedRecords(t *testing.T) {
 		t.Errorf("Expected %v, got %v", "fefc72b4-d8df-4039-9fb9-bfcb18066a2b", externalID)
 	}
 
-	msg = p.messages[3]
+	msg = s.messages[3]
 	email, err = jsonparser.GetString(msg.Value, "data", "email")
 	if err != nil {
 		t.Fatal(err)
@@ -100,6 +108,198 @@ func TestFetchUnprocessedRecords(t *testing.T) {
 	}
 }
 
+func TestProcessEvents_Coalesce(t *testing.T) {
+	db, eq, cleanup := setup(t)
+	defer cleanup()
+
+	coalesce = true
+	defer func() { coalesce = false }()
+
+	events := []*eventqueue.Event{
+		{
+			ExternalID: []byte("fefc72b4-d8df-4039-9fb9-bfcb18066a2b"),
+			TableName:  "users",
+			Statement:  "INSERT",
+			Data:       []byte(`{ "email": "j@blendle.com", "name": "Jurre" }`),
+		},
+		{
+			ExternalID: []byte("fefc72b4-d8df-4039-9fb9-bfcb18066a2b"),
+			TableName:  "users",
+			Statement:  "UPDATE",
+			Data:       []byte(`{ "email": "jurre@blendle.com" }`),
+		},
+	}
+	if err := insert(db, events); err != nil {
+		t.Fatalf("Error inserting events: %v", err)
+	}
+
+	s := &mockSink{}
+	if err := ProcessEvents(context.Background(), s, eq); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(s.messages) != 1 || s.messages[0].Statement != "INSERT" {
+		t.Fatalf("Expected a single INSERT message, got %+v", s.messages)
+	}
+
+	email, err := jsonparser.GetString(s.messages[0].Value, "data", "email")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if email != "jurre@blendle.com" {
+		t.Errorf("Data did not match. Expected %v, got %v", "jurre@blendle.com", email)
+	}
+
+	backlog, err := eq.UnprocessedEventsBacklog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if backlog.Count != 0 {
+		t.Errorf("Expected coalesced events to be processed, got %d unprocessed", backlog.Count)
+	}
+}
+
+func TestProcessQueue_Stopped(t *testing.T) {
+	db, eq, cleanup := setup(t)
+	defer cleanup()
+
+	events := []*eventqueue.Event{
+		{
+			ExternalID: []byte("fefc72b4-d8df-4039-9fb9-bfcb18066a2b"),
+			TableName:  "users",
+			Statement:  "UPDATE",
+			Data:       []byte(`{ "email": "jurre@blendle.com" }`),
+		},
+	}
+	if err := insert(db, events); err != nil {
+		t.Fatalf("Error inserting events: %v", err)
+	}
+
+	stop := make(chan struct{})
+	close(stop)
+
+	s := &mockSink{}
+	if _, err := processQueue(context.Background(), s, eq, stop); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(s.messages) != 0 {
+		t.Errorf("Expected no messages to be produced after stopping, got %d", len(s.messages))
+	}
+}
+
+func TestWaitForNotification_Reconnect(t *testing.T) {
+	l := &fakeListener{notify: make(chan *pq.Notification, 2)}
+	stop := make(chan struct{})
+	drained := make(chan struct{}, 2)
+	done := make(chan error, 1)
+	go func() {
+		done <- waitForNotification(l, 0, stop, func() error {
+			drained <- struct{}{}
+			return nil
+		})
+	}()
+
+	// pq sends a nil notification after reconnecting, as notifications may
+	// have been dropped while the listener was disconnected.
+	l.notify <- nil
+	l.notify <- &pq.Notification{Channel: "outbound_event_queue", Extra: "INSERT"}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-drained:
+		case <-time.After(time.Second):
+			t.Fatalf("Expected queue to be drained for notification %d", i)
+		}
+	}
+
+	close(stop)
+	if err := <-done; err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestWaitForNotification_Poll(t *testing.T) {
+	// A listener that lost its connection without noticing never notifies.
+	l := &fakeListener{notify: make(chan *pq.Notification)}
+	stop := make(chan struct{})
+	drained := make(chan struct{}, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- waitForNotification(l, 10*time.Millisecond, stop, func() error {
+			select {
+			case drained <- struct{}{}:
+			default:
+			}
+			return nil
+		})
+	}()
+
+	select {
+	case <-drained:
+	case <-time.After(time.Second):
+		t.Fatal("Expected queue to be drained without notifications")
+	}
+
+	close(stop)
+	if err := <-done; err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestWaitForNotification_Errors(t *testing.T) {
+	l := &fakeListener{notify: make(chan *pq.Notification, 1)}
+	l.notify <- nil
+	err := waitForNotification(l, 0, nil, func() error { return errors.New("boom") })
+	if err == nil || err.Error() != "boom" {
+		t.Errorf("Expected drain error, got %v", err)
+	}
+
+	close(l.notify)
+	if err = waitForNotification(l, 0, nil, func() error { return nil }); err == nil {
+		t.Error("Expected error for closed listener")
+	}
+}
+
+func TestPollQueue(t *testing.T) {
+	stop := make(chan struct{})
+	stopped := false
+	requested := []time.Duration{}
+	pollAfter = func(d time.Duration) <-chan time.Time {
+		requested = append(requested, d)
+		if stopped {
+			return nil
+		}
+		c := make(chan time.Time, 1)
+		c <- time.Time{}
+		return c
+	}
+	defer func() { pollAfter = time.After }()
+
+	polls := 0
+	batches := []int{0, 0, 0, 1, 0}
+	err := pollQueue(5*time.Millisecond, 20*time.Millisecond, stop, func() (int, error) {
+		polls++
+		if polls == len(batches) {
+			stopped = true
+			close(stop)
+		}
+		return batches[polls-1], nil
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// The interval doubles while idle, up to the maximum, and is reset after
+	// events were found.
+	expected := []time.Duration{5, 10, 20, 20, 5, 10}
+	for i := range expected {
+		expected[i] *= time.Millisecond
+	}
+	if !reflect.DeepEqual(requested, expected) {
+		t.Errorf("Expected intervals %v, got %v", expected, requested)
+	}
+}
+
 // Helpers
 
 func setup(t *testing.T) (*sql.DB, *eventqueue.Queue, func()) {
@@ -111,7 +311,7 @@ func setup(t *testing.T) (*sql.DB, *eventqueue.Queue, func()) {
 	}
 
 	eq := eventqueue.NewWithDB(db)
-	if err := eq.ConfigureOutboundEventQueueAndTriggers("./sql"); err != nil {
+	if _, err := eq.Migrate(context.Background()); err != nil {
 		t.Fatal(err)
 	}
 
@@ -175,19 +375,339 @@ func TestParseTopicNamespace(t *testing.T) {
 	}
 }
 
-type mockProducer struct {
-	messages []*kafka.Message
+var messageKeyTests = []struct {
+	data   string
+	column string
+	out    string
+}{
+	{`{"email": "jurre@blendle.com"}`, "", "external-id"},
+	{`{"email": "jurre@blendle.com"}`, "email", "jurre@blendle.com"},
+	{`{"id": 42}`, "id", "42"},
+	{`{"email": null}`, "email", "external-id"},
+	{`{"name": "jurre"}`, "email", "external-id"},
+	{`{}`, "email", "external-id"},
+}
+
+func TestMessageKey(t *testing.T) {
+	for _, tt := range messageKeyTests {
+		t.Run(tt.data+" "+tt.column, func(t *testing.T) {
+			event := &eventqueue.Event{ExternalID: []byte("external-id"), Data: []byte(tt.data)}
+
+			actual := messageKey(event, tt.column)
+			if string(actual) != tt.out {
+				t.Errorf("messageKey(%s, %q) => %q, want: %q", tt.data, tt.column, actual, tt.out)
+			}
+		})
+	}
+}
+
+func TestNewMessage(t *testing.T) {
+	topicNamespace = "shop"
+	tables = config.Tables{
+		"users": &config.TableConfig{
+			Topic:   "users-changes",
+			Key:     "email",
+			Headers: true,
+			Transforms: config.Transforms{
+				Exclude: []string{"password"},
+			},
+		},
+	}
+	defer func() { tables = nil }()
+
+	event := &eventqueue.Event{
+		UUID:       "d6521ce5-4068-45e4-a9ad-c0949033a55b",
+		ExternalID: []byte("fefc72b4-d8df-4039-9fb9-bfcb18066a2b"),
+		TableName:  "users",
+		Statement:  "INSERT",
+		Data:       []byte(`{"email": "jurre@blendle.com", "password": "secret"}`),
+	}
+
+	msg, err := newMessage(event, tables.Get("users"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if msg.Topic != "users-changes" {
+		t.Errorf("Expected topic 'users-changes', got %q", msg.Topic)
+	}
+
+	if string(msg.Key) != "jurre@blendle.com" {
+		t.Errorf("Expected key 'jurre@blendle.com', got %q", msg.Key)
+	}
+
+	if len(msg.Headers) == 0 {
+		t.Error("Expected message to have headers")
+	}
+
+	if _, _, _, err = jsonparser.Get(msg.Value, "data", "password"); err != jsonparser.KeyPathNotFoundError {
+		t.Errorf("Expected password to be excluded, got %s", msg.Value)
+	}
+
+	event.TableName = "products"
+	msg, err = newMessage(event, tables.Get("products"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if msg.Topic != "pg2kafka.shop.products" {
+		t.Errorf("Expected topic 'pg2kafka.shop.products', got %q", msg.Topic)
+	}
+}
+
+func TestNewMessage_Schema(t *testing.T) {
+	schemaChangesTopic = "pg2kafka.shop.schema_changes"
+	tables = config.Tables{
+		"users": &config.TableConfig{
+			Key: "email",
+			Transforms: config.Transforms{
+				Exclude: []string{"password_digest"},
+				Rename:  map[string]string{"email": "email_address"},
+			},
+		},
+	}
+	defer func() { tables = nil }()
+
+	event := &eventqueue.Event{
+		UUID:      "d6521ce5-4068-45e4-a9ad-c0949033a55b",
+		TableName: "users",
+		Statement: eventqueue.StatementSchema,
+		Data: []byte(`{"columns": [
+			{"name": "email", "type": "text", "nullable": true, "position": 1},
+			{"name": "password_digest", "type": "text", "nullable": false, "position": 2}
+		]}`),
+	}
+
+	msg, err := newMessage(event, tables.Get("users"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if msg.Topic != "pg2kafka.shop.schema_changes" {
+		t.Errorf("Expected topic 'pg2kafka.shop.schema_changes', got %q", msg.Topic)
+	}
+
+	if string(msg.Key) != "users" {
+		t.Errorf("Expected key 'users', got %q", msg.Key)
+	}
+
+	columns, _, _, err := jsonparser.Get(msg.Value, "data", "columns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `[{"name":"email_address","type":"text","nullable":true,"position":1}]`
+	if string(columns) != expected {
+		t.Errorf("Expected transformed columns %s, got %s", expected, columns)
+	}
+}
+
+func TestTransformSchema(t *testing.T) {
+	schema := &eventqueue.TableSchema{Columns: []*eventqueue.Column{
+		{Name: "email", Type: "text"},
+		{Name: "password", Type: "text"},
+		{Name: "price", Type: "numeric(10,2)"},
+	}}
+
+	transformed := transformSchema(schema, config.Transforms{
+		Exclude: []string{"password"},
+		Rename:  map[string]string{"email": "email_address"},
+	})
+
+	if len(transformed.Columns) != 2 {
+		t.Fatalf("Expected 2 columns, got %d", len(transformed.Columns))
+	}
+
+	if transformed.Columns[0].Name != "email_address" || transformed.Columns[1].Name != "price" {
+		t.Errorf("Unexpected columns: %+v, %+v", transformed.Columns[0], transformed.Columns[1])
+	}
+
+	if schema.Columns[0].Name != "email" {
+		t.Errorf("Expected cached schema not to be modified, got %+v", schema.Columns[0])
+	}
+}
+
+var losslessNumbersTests = []struct {
+	name string
+	in   string
+	out  string
+}{
+	{"numeric", `{"price": 12345678901234567.89}`, `{"price":"12345678901234567.89"}`},
+	{"negative", `{"price": -0.10}`, `{"price":"-0.10"}`},
+	{"bigint", `{"id": 9007199254740993}`, `{"id":"9007199254740993"}`},
+	{"integer", `{"quantity": 3}`, `{"quantity":3}`},
+	{"money", `{"balance": "$1,234.56"}`, `{"balance":"$1,234.56"}`},
+	{"null", `{"price": null}`, `{"price":null}`},
+	{"array", `{"prices": [1.10, null, 2.20]}`, `{"prices":["1.10",null,"2.20"]}`},
+	{"unknown column", `{"discount": 0.5}`, `{"discount":0.5}`},
+}
+
+func TestLosslessNumbers(t *testing.T) {
+	schema := &eventqueue.TableSchema{Columns: []*eventqueue.Column{
+		{Name: "id", Type: "bigint"},
+		{Name: "price", Type: "numeric(19,2)"},
+		{Name: "prices", Type: "numeric[]"},
+		{Name: "quantity", Type: "integer"},
+		{Name: "balance", Type: "money"},
+	}}
+
+	for _, tt := range losslessNumbersTests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual, err := losslessNumbers([]byte(tt.in), schema)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if string(actual) != tt.out {
+				t.Errorf("losslessNumbers(%s) => %s, want: %s", tt.in, actual, tt.out)
+			}
+		})
+	}
+}
+
+func TestNewMessage_LosslessNumbers(t *testing.T) {
+	tables = config.Tables{
+		"orders": &config.TableConfig{
+			LosslessNumbers: true,
+			Transforms:      config.Transforms{Rename: map[string]string{"total": "total_amount"}},
+		},
+	}
+	defer func() { tables = nil }()
+
+	event := &eventqueue.Event{
+		TableName: "orders",
+		Statement: "INSERT",
+		Data:      []byte(`{"total": 19999999999999999.99}`),
+		TableSchema: &eventqueue.TableSchema{Columns: []*eventqueue.Column{
+			{Name: "total", Type: "numeric(19,2)"},
+		}},
+	}
+
+	msg, err := newMessage(event, tables.Get("orders"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	total, err := jsonparser.GetString(msg.Value, "data", "total_amount")
+	if err != nil || total != "19999999999999999.99" {
+		t.Errorf("Expected total to be encoded as string, got %s", msg.Value)
+	}
+
+	if _, _, _, err = jsonparser.Get(msg.Value, "schema"); err != jsonparser.KeyPathNotFoundError {
+		t.Errorf("Expected no schema section, got %s", msg.Value)
+	}
+}
+
+func TestNewMessage_Protobuf(t *testing.T) {
+	protobufEncoder = protobuf.NewEncoder("")
+	tables = config.Tables{
+		"products": &config.TableConfig{Format: config.FormatProtobuf, Headers: true},
+	}
+	defer func() { tables = nil }()
+
+	event := &eventqueue.Event{
+		UUID:      "d6521ce5-4068-45e4-a9ad-c0949033a55b",
+		TableName: "products",
+		Statement: "INSERT",
+		Data:      []byte(`{"sku": "CM01-R"}`),
+		TableSchema: &eventqueue.TableSchema{Columns: []*eventqueue.Column{
+			{Name: "sku", Type: "text", Position: 1},
+		}},
+	}
+
+	msg, err := newMessage(event, tables.Get("products"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Contains(msg.Value, []byte("CM01-R")) || json.Valid(msg.Value) {
+		t.Errorf("Expected protobuf encoded value, got %q", msg.Value)
+	}
+
+	if msg.Headers[0].Key != "content-type" || string(msg.Headers[0].Value) != protobuf.ContentType {
+		t.Errorf("Expected protobuf content-type, got %s=%s", msg.Headers[0].Key, msg.Headers[0].Value)
+	}
+
+	// The table was dropped before the event was published.
+	event.TableSchema = nil
+	if _, err = newMessage(event, tables.Get("products")); err != nil {
+		t.Errorf("Expected event without schema to be encoded, got %v", err)
+	}
+}
+
+func TestMessageHeaders(t *testing.T) {
+	event := &eventqueue.Event{
+		UUID:      "d6521ce5-4068-45e4-a9ad-c0949033a55b",
+		TableName: "users",
+		Schema:    "public",
+		Statement: "UPDATE",
+		TxID:      1337,
+	}
+
+	expected := map[string]string{
+		"content-type":       "application/json",
+		"pg2kafka.uuid":      "d6521ce5-4068-45e4-a9ad-c0949033a55b",
+		"pg2kafka.statement": "UPDATE",
+		"pg2kafka.schema":    "public",
+		"pg2kafka.table":     "users",
+		"pg2kafka.txid":      "1337",
+	}
+
+	for _, h := range messageHeaders(event, "application/json") {
+		if v, ok := expected[h.Key]; ok && v != string(h.Value) {
+			t.Errorf("Expected header %q to be %q, got %q", h.Key, v, h.Value)
+		}
+		delete(expected, h.Key)
+	}
+
+	if len(expected) != 0 {
+		t.Errorf("Missing headers: %v", expected)
+	}
+}
+
+func TestProducerConfig(t *testing.T) {
+	cm := producerConfig(config.KafkaConfig{
+		Broker: "localhost:9092",
+		Properties: map[string]string{
+			"security.protocol": "SASL_SSL",
+			"compression.codec": "lz4",
+		},
+	}, "pg2kafka-0")
+
+	expected := map[string]string{
+		"client.id":         "pg2kafka-0",
+		"bootstrap.servers": "localhost:9092",
+		"partitioner":       "murmur2",
+		"compression.codec": "lz4",
+		"security.protocol": "SASL_SSL",
+	}
+
+	for key, value := range expected {
+		if actual, _ := cm.Get(key, nil); actual != value {
+			t.Errorf("Expected %s to be %q, got %v", key, value, actual)
+		}
+	}
+}
+
+type mockSink struct {
+	messages []*sink.Message
+}
+
+func (s *mockSink) Close() error {
+	return nil
+}
+func (s *mockSink) Publish(ctx context.Context, msgs []*sink.Message) (int, error) {
+	s.messages = append(s.messages, msgs...)
+	return len(msgs), nil
 }
 
-func (p *mockProducer) Close() {
+type fakeListener struct {
+	notify chan *pq.Notification
 }
-func (p *mockProducer) Flush(timeout int) int {
-	return 0
+
+func (l *fakeListener) NotificationChannel() <-chan *pq.Notification {
+	return l.notify
 }
-func (p *mockProducer) Produce(msg *kafka.Message, deliveryChan chan kafka.Event) error {
-	p.messages = append(p.messages, msg)
-	go func() {
-		deliveryChan <- msg
-	}()
+
+func (l *fakeListener) Ping() error {
 	return nil
 }
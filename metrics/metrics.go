@@ -0,0 +1,146 @@
+// Package metrics defines the Prometheus metrics exposed by pg2kafka.
+package metrics
+
+import (
+	"github.com/blendle/pg2kafka/eventqueue"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "pg2kafka"
+
+var (
+	// EventsFetched counts the events fetched from the outbound event queue.
+	EventsFetched = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "events_fetched_total",
+		Help:      "Number of events fetched from the outbound event queue.",
+	}, []string{"table", "statement"})
+
+	// EventsProduced counts the events acknowledged by the sink.
+	EventsProduced = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "events_produced_total",
+		Help:      "Number of events delivered to the sink.",
+	}, []string{"table", "statement"})
+
+	// EventsFailed counts the events the sink failed to deliver.
+	EventsFailed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "events_failed_total",
+		Help:      "Number of events that could not be delivered to the sink.",
+	}, []string{"table", "statement"})
+
+	// EventsCoalesced counts the events merged into a later event of the same
+	// row instead of being published.
+	EventsCoalesced = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "events_coalesced_total",
+		Help:      "Number of events merged into a later event of the same row.",
+	}, []string{"table"})
+
+	// EventsWithoutSchema counts the events published without the schema of
+	// their table, because the table no longer exists.
+	EventsWithoutSchema = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "events_without_schema_total",
+		Help:      "Number of events published without schema because their table no longer exists.",
+	}, []string{"table"})
+
+	// QueueDrains counts how often the queue was processed, by what triggered
+	// it: a notification, the listener reconnecting, or polling.
+	QueueDrains = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "queue_drains_total",
+		Help:      "Number of times the queue was processed, by trigger.",
+	}, []string{"trigger"})
+
+	// EventAge observes the time between an event being created and it being
+	// delivered to the sink.
+	EventAge = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "event_age_at_delivery_seconds",
+		Help:      "Time between an event being created and it being delivered.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 4, 12),
+	}, []string{"table"})
+
+	// DeliveryDuration observes how long the sink takes to acknowledge a
+	// batch of events.
+	DeliveryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "delivery_duration_seconds",
+		Help:      "Time the sink takes to acknowledge a batch of events.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"sink"})
+
+	// TableDrift is 1 for every problem the last drift check found with a
+	// tracked table.
+	TableDrift = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "table_drift",
+		Help:      "Problems found with tracked tables, such as missing triggers.",
+	}, []string{"table", "problem"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		EventsFetched,
+		EventsProduced,
+		EventsFailed,
+		EventsCoalesced,
+		EventsWithoutSchema,
+		QueueDrains,
+		EventAge,
+		DeliveryDuration,
+		TableDrift,
+	)
+}
+
+// Backlogger is implemented by queues that can report their backlog.
+type Backlogger interface {
+	UnprocessedEventsBacklog() (*eventqueue.Backlog, error)
+}
+
+// BacklogCollector is a prometheus.Collector querying the backlog of
+// unprocessed events every time metrics are collected.
+type BacklogCollector struct {
+	queue Backlogger
+
+	count     *prometheus.Desc
+	oldestAge *prometheus.Desc
+}
+
+// NewBacklogCollector creates a new BacklogCollector for the given queue.
+func NewBacklogCollector(queue Backlogger) *BacklogCollector {
+	return &BacklogCollector{
+		queue: queue,
+		count: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "unprocessed_events"),
+			"Number of events waiting to be processed.",
+			nil, nil,
+		),
+		oldestAge: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "oldest_unprocessed_event_age_seconds"),
+			"Time since the oldest unprocessed event was created.",
+			nil, nil,
+		),
+	}
+}
+
+// Describe implements the prometheus.Collector interface.
+func (c *BacklogCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.count
+	ch <- c.oldestAge
+}
+
+// Collect implements the prometheus.Collector interface.
+func (c *BacklogCollector) Collect(ch chan<- prometheus.Metric) {
+	backlog, err := c.queue.UnprocessedEventsBacklog()
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(c.count, err)
+		ch <- prometheus.NewInvalidMetric(c.oldestAge, err)
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.count, prometheus.GaugeValue, float64(backlog.Count))
+	ch <- prometheus.MustNewConstMetric(c.oldestAge, prometheus.GaugeValue, backlog.OldestAge.Seconds())
+}
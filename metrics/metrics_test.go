@@ -0,0 +1,47 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/blendle/pg2kafka/eventqueue"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+type mockBacklogger struct {
+	backlog *eventqueue.Backlog
+	err     error
+}
+
+func (b *mockBacklogger) UnprocessedEventsBacklog() (*eventqueue.Backlog, error) {
+	return b.backlog, b.err
+}
+
+func TestBacklogCollector(t *testing.T) {
+	c := NewBacklogCollector(&mockBacklogger{
+		backlog: &eventqueue.Backlog{Count: 42, OldestAge: 90 * time.Second},
+	})
+
+	expected := `
+# HELP pg2kafka_oldest_unprocessed_event_age_seconds Time since the oldest unprocessed event was created.
+# TYPE pg2kafka_oldest_unprocessed_event_age_seconds gauge
+pg2kafka_oldest_unprocessed_event_age_seconds 90
+# HELP pg2kafka_unprocessed_events Number of events waiting to be processed.
+# TYPE pg2kafka_unprocessed_events gauge
+pg2kafka_unprocessed_events 42
+`
+
+	if err := testutil.CollectAndCompare(c, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBacklogCollector_Error(t *testing.T) {
+	c := NewBacklogCollector(&mockBacklogger{err: errors.New("connection refused")})
+
+	if _, err := testutil.CollectAndLint(c); err == nil {
+		t.Error("Expected an error, got nil")
+	}
+}
@@ -0,0 +1,129 @@
+package protobuf
+
+import (
+	"github.com/blendle/pg2kafka/eventqueue"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// eventFile describes the message wrapping every event, equivalent to:
+//
+//	syntax = "proto3";
+//	package pg2kafka;
+//
+//	import "google/protobuf/any.proto";
+//	import "google/protobuf/timestamp.proto";
+//
+//	message Event {
+//	  string uuid = 1;
+//	  string statement = 2;
+//	  string schema = 3;
+//	  string table = 4;
+//	  string external_id = 5;
+//	  int64 txid = 6;
+//	  google.protobuf.Timestamp created_at = 7;
+//	  Source source = 8;
+//	  // A message named after the schema and table, such as
+//	  // pg2kafka.tables.public.products.
+//	  google.protobuf.Any data = 9;
+//
+//	  message Source {
+//	    string current_user = 1;
+//	    string session_user = 2;
+//	    string application_name = 3;
+//	    string actor = 4;
+//	  }
+//	}
+var eventFile = &descriptorpb.FileDescriptorProto{
+	Name:       proto.String("pg2kafka/event.proto"),
+	Package:    proto.String("pg2kafka"),
+	Syntax:     proto.String("proto3"),
+	Dependency: []string{"google/protobuf/any.proto", "google/protobuf/timestamp.proto"},
+	MessageType: []*descriptorpb.DescriptorProto{{
+		Name: proto.String("Event"),
+		Field: []*descriptorpb.FieldDescriptorProto{
+			field("uuid", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+			field("statement", 2, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+			field("schema", 3, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+			field("table", 4, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+			field("external_id", 5, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+			field("txid", 6, descriptorpb.FieldDescriptorProto_TYPE_INT64, ""),
+			field("created_at", 7, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".google.protobuf.Timestamp"),
+			field("source", 8, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".pg2kafka.Event.Source"),
+			field("data", 9, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".google.protobuf.Any"),
+		},
+		NestedType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("Source"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				field("current_user", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+				field("session_user", 2, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+				field("application_name", 3, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+				field("actor", 4, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+			},
+		}},
+	}},
+}
+
+// eventDescriptor is the descriptor of the pg2kafka.Event message.
+var eventDescriptor = func() protoreflect.MessageDescriptor {
+	fd, err := protodesc.NewFile(eventFile, protoregistry.GlobalFiles)
+	if err != nil {
+		panic(err)
+	}
+
+	return fd.Messages().Get(0)
+}()
+
+func field(name string, number int32, typ descriptorpb.FieldDescriptorProto_Type, typeName string) *descriptorpb.FieldDescriptorProto {
+	f := &descriptorpb.FieldDescriptorProto{
+		Name:   proto.String(name),
+		Number: proto.Int32(number),
+		Label:  descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+		Type:   typ.Enum(),
+	}
+	if typeName != "" {
+		f.TypeName = proto.String(typeName)
+	}
+
+	return f
+}
+
+// wrap builds the pg2kafka.Event message of an event.
+func wrap(event *eventqueue.Event, data *anypb.Any) *dynamicpb.Message {
+	msg := dynamicpb.NewMessage(eventDescriptor)
+	fields := eventDescriptor.Fields()
+	setString := func(m *dynamicpb.Message, fields protoreflect.FieldDescriptors, name, value string) {
+		if value != "" {
+			m.Set(fields.ByName(protoreflect.Name(name)), protoreflect.ValueOfString(value))
+		}
+	}
+
+	setString(msg, fields, "uuid", event.UUID)
+	setString(msg, fields, "statement", event.Statement)
+	setString(msg, fields, "schema", event.Schema)
+	setString(msg, fields, "table", event.TableName)
+	setString(msg, fields, "external_id", string(event.ExternalID))
+	if event.TxID != 0 {
+		msg.Set(fields.ByName("txid"), protoreflect.ValueOfInt64(event.TxID))
+	}
+	msg.Set(fields.ByName("created_at"), protoreflect.ValueOfMessage(timestamppb.New(event.CreatedAt).ProtoReflect()))
+	msg.Set(fields.ByName("data"), protoreflect.ValueOfMessage(data.ProtoReflect()))
+
+	if event.Source != nil {
+		sd := fields.ByName("source").Message()
+		source := dynamicpb.NewMessage(sd)
+		setString(source, sd.Fields(), "current_user", event.Source.CurrentUser)
+		setString(source, sd.Fields(), "session_user", event.Source.SessionUser)
+		setString(source, sd.Fields(), "application_name", event.Source.ApplicationName)
+		setString(source, sd.Fields(), "actor", event.Source.Actor)
+		msg.Set(fields.ByName("source"), protoreflect.ValueOfMessage(source))
+	}
+
+	return msg
+}
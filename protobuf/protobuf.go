@@ -0,0 +1,472 @@
+// Package protobuf encodes events as protobuf messages. The data of an event
+// is encoded as a message built at runtime from the columns of its table, and
+// wrapped in a pg2kafka.Event message carrying the event metadata.
+package protobuf
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/blendle/pg2kafka/eventqueue"
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	// ContentType is the content type of encoded events.
+	ContentType = "application/x-protobuf"
+
+	// TablesPackage is the protobuf package of the table messages, which are
+	// named after their schema and table, such as
+	// `pg2kafka.tables.public.products`.
+	TablesPackage = "pg2kafka.tables"
+)
+
+// Encoder encodes events as protobuf messages. It builds a message type for
+// every table from its columns, and rebuilds it when the columns change.
+type Encoder struct {
+	descriptorSetFile string
+
+	mu sync.Mutex
+	// tables holds the message types by qualified table name.
+	tables map[string]*table
+}
+
+type table struct {
+	schema  *eventqueue.TableSchema
+	file    *descriptorpb.FileDescriptorProto
+	message protoreflect.MessageDescriptor
+	fields  map[string]protoreflect.FieldDescriptor
+}
+
+// NewEncoder creates a new Encoder. If descriptorSetFile is not empty, a
+// FileDescriptorSet describing every message the Encoder produced is written
+// to it whenever a message type is added or changed, so consumers can decode
+// the messages without generated code.
+func NewEncoder(descriptorSetFile string) *Encoder {
+	return &Encoder{
+		descriptorSetFile: descriptorSetFile,
+		tables:            map[string]*table{},
+	}
+}
+
+// Encode encodes an event as a pg2kafka.Event message, using the schema of its
+// table to build the message of its data. Without a schema, such as when the
+// table no longer exists, the message last built for the table is used, or one
+// encoding every column as a string.
+func (e *Encoder) Encode(event *eventqueue.Event, schema *eventqueue.TableSchema) ([]byte, error) {
+	if schema == nil {
+		var err error
+		if schema, err = e.fallbackSchema(event); err != nil {
+			return nil, errors.Wrapf(err, "error encoding data of event %s", event.UUID)
+		}
+	}
+
+	t, err := e.table(event.Schema, event.TableName, schema)
+	if err != nil {
+		return nil, err
+	}
+
+	row := dynamicpb.NewMessage(t.message)
+	if err = setColumns(row, t.fields, event.Data); err != nil {
+		return nil, errors.Wrapf(err, "error encoding data of event %s", event.UUID)
+	}
+
+	data, err := anypb.New(row)
+	if err != nil {
+		return nil, err
+	}
+
+	return proto.Marshal(wrap(event, data))
+}
+
+// DescriptorSet returns the descriptors of the wrapper message, and of the
+// table messages produced so far.
+func (e *Encoder) DescriptorSet() *descriptorpb.FileDescriptorSet {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.descriptorSet()
+}
+
+func (e *Encoder) descriptorSet() *descriptorpb.FileDescriptorSet {
+	set := &descriptorpb.FileDescriptorSet{
+		File: []*descriptorpb.FileDescriptorProto{
+			protodesc.ToFileDescriptorProto(anypb.File_google_protobuf_any_proto),
+			protodesc.ToFileDescriptorProto(timestamppb.File_google_protobuf_timestamp_proto),
+			eventFile,
+		},
+	}
+
+	names := make([]string, 0, len(e.tables))
+	for name := range e.tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		set.File = append(set.File, e.tables[name].file)
+	}
+
+	return set
+}
+
+// table returns the message type of a table, building it if the table was
+// not seen before or its columns changed.
+func (e *Encoder) table(namespace, name string, schema *eventqueue.TableSchema) (*table, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	key := qualifiedName(namespace, name)
+	if t, ok := e.tables[key]; ok && sameColumns(t.schema, schema) {
+		return t, nil
+	}
+
+	t, err := newTable(namespace, name, schema)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error building protobuf message for table %s", key)
+	}
+	e.tables[key] = t
+
+	if err = e.writeDescriptorSet(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// fallbackSchema returns the schema of the message last built for the table of
+// an event, or a schema describing every column in its data as text.
+func (e *Encoder) fallbackSchema(event *eventqueue.Event) (*eventqueue.TableSchema, error) {
+	e.mu.Lock()
+	t, ok := e.tables[qualifiedName(event.Schema, event.TableName)]
+	e.mu.Unlock()
+	if ok {
+		return t.schema, nil
+	}
+
+	columns := map[string]json.RawMessage{}
+	if err := json.Unmarshal(event.Data, &columns); err != nil {
+		return nil, errors.Wrap(err, "error parsing event data")
+	}
+
+	names := make([]string, 0, len(columns))
+	for name := range columns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	schema := &eventqueue.TableSchema{Columns: []*eventqueue.Column{}}
+	for i, name := range names {
+		column := &eventqueue.Column{Name: name, Type: "text", Nullable: true, Position: i + 1}
+		schema.Columns = append(schema.Columns, column)
+	}
+	return schema, nil
+}
+
+// writeDescriptorSet replaces the descriptor set file, if one is configured.
+func (e *Encoder) writeDescriptorSet() error {
+	if e.descriptorSetFile == "" {
+		return nil
+	}
+
+	b, err := proto.Marshal(e.descriptorSet())
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first, so readers never see a partial set.
+	tmp, err := ioutil.TempFile(filepath.Dir(e.descriptorSetFile), ".pg2kafka-descriptors")
+	if err != nil {
+		return errors.Wrap(err, "error writing descriptor set")
+	}
+	if _, err = tmp.Write(b); err != nil {
+		tmp.Close()           // nolint: errcheck
+		os.Remove(tmp.Name()) // nolint: errcheck
+		return errors.Wrap(err, "error writing descriptor set")
+	}
+	if err = tmp.Close(); err != nil {
+		return errors.Wrap(err, "error writing descriptor set")
+	}
+
+	return errors.Wrap(os.Rename(tmp.Name(), e.descriptorSetFile), "error writing descriptor set")
+}
+
+// newTable builds the message type of a table. Tables in a schema get a
+// package of their own, such as `pg2kafka.tables.public`, so tables with the
+// same name in different schemas don't clash.
+func newTable(namespace, name string, schema *eventqueue.TableSchema) (*table, error) {
+	pkg, path := TablesPackage, "pg2kafka/tables/"
+	if namespace != "" {
+		pkg += "." + identifier(namespace)
+		path += identifier(namespace) + "/"
+	}
+
+	msg := &descriptorpb.DescriptorProto{Name: proto.String(identifier(name))}
+	file := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String(path + identifier(name) + ".proto"),
+		Package: proto.String(pkg),
+		Syntax:  proto.String("proto2"),
+	}
+
+	numbers := map[string]int32{}
+	names := map[string]bool{}
+	for i, c := range schema.Columns {
+		// Column positions do not change when other columns are added or
+		// dropped, so they are used as field numbers to keep messages
+		// compatible.
+		number := int32(c.Position)
+		if number <= 0 {
+			number = int32(i + 1)
+		}
+		numbers[c.Name] = number
+
+		typ, array := strings.TrimSuffix(c.Type, "[]"), strings.HasSuffix(c.Type, "[]")
+		// Columns such as `a-b` and `a_b` have the same identifier, the
+		// field number is added to the ones that follow the first.
+		fieldName := identifier(c.Name)
+		for names[fieldName] {
+			fieldName += "_" + strconv.Itoa(int(number))
+		}
+		names[fieldName] = true
+
+		field := &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String(fieldName),
+			JsonName: proto.String(c.Name),
+			Number:   proto.Int32(number),
+			Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			Type:     fieldType(typ).Enum(),
+		}
+		if array {
+			field.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+		}
+		if fieldType(typ) == descriptorpb.FieldDescriptorProto_TYPE_MESSAGE {
+			field.TypeName = proto.String(".google.protobuf.Timestamp")
+			if len(file.Dependency) == 0 {
+				file.Dependency = []string{"google/protobuf/timestamp.proto"}
+			}
+		}
+		msg.Field = append(msg.Field, field)
+	}
+	file.MessageType = []*descriptorpb.DescriptorProto{msg}
+
+	fd, err := protodesc.NewFile(file, protoregistry.GlobalFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	md := fd.Messages().Get(0)
+	fields := map[string]protoreflect.FieldDescriptor{}
+	for name, number := range numbers {
+		fields[name] = md.Fields().ByNumber(protoreflect.FieldNumber(number))
+	}
+
+	return &table{schema: schema, file: file, message: md, fields: fields}, nil
+}
+
+// fieldType returns the protobuf type of a Postgres type. Types without an
+// exact protobuf equivalent, such as numeric, are encoded as strings.
+func fieldType(typ string) descriptorpb.FieldDescriptorProto_Type {
+	switch {
+	case typ == "boolean":
+		return descriptorpb.FieldDescriptorProto_TYPE_BOOL
+	case typ == "smallint" || typ == "integer":
+		return descriptorpb.FieldDescriptorProto_TYPE_INT32
+	case typ == "bigint":
+		return descriptorpb.FieldDescriptorProto_TYPE_INT64
+	case typ == "real":
+		return descriptorpb.FieldDescriptorProto_TYPE_FLOAT
+	case typ == "double precision":
+		return descriptorpb.FieldDescriptorProto_TYPE_DOUBLE
+	case typ == "bytea":
+		return descriptorpb.FieldDescriptorProto_TYPE_BYTES
+	case strings.HasPrefix(typ, "timestamp"):
+		return descriptorpb.FieldDescriptorProto_TYPE_MESSAGE
+	default:
+		return descriptorpb.FieldDescriptorProto_TYPE_STRING
+	}
+}
+
+// setColumns sets the fields of msg from the JSON encoded columns in data.
+// Null values leave their field unset. Events enqueued before a column was
+// dropped, or its type changed, are still published: columns without a field
+// are skipped, and values that don't fit the type of their field leave it
+// unset.
+func setColumns(msg *dynamicpb.Message, fields map[string]protoreflect.FieldDescriptor, data json.RawMessage) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	columns := map[string]interface{}{}
+	if err := dec.Decode(&columns); err != nil {
+		return errors.Wrap(err, "error parsing event data")
+	}
+
+	for name, v := range columns {
+		fd, ok := fields[name]
+		if !ok || v == nil {
+			continue
+		}
+
+		if !fd.IsList() {
+			if value, err := fieldValue(fd, v); err == nil {
+				msg.Set(fd, value)
+			}
+			continue
+		}
+
+		elements, ok := v.([]interface{})
+		if !ok {
+			continue
+		}
+		values := make([]protoreflect.Value, 0, len(elements))
+		for _, element := range elements {
+			if element == nil {
+				continue
+			}
+			value, err := fieldValue(fd, element)
+			if err != nil {
+				values = nil
+				break
+			}
+			values = append(values, value)
+		}
+		if values == nil {
+			continue
+		}
+		list := msg.Mutable(fd).List()
+		for _, value := range values {
+			list.Append(value)
+		}
+	}
+
+	return nil
+}
+
+// fieldValue converts a JSON value, as decoded with UseNumber, to the type of
+// a field.
+func fieldValue(fd protoreflect.FieldDescriptor, v interface{}) (protoreflect.Value, error) {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		b, ok := v.(bool)
+		if !ok {
+			return protoreflect.Value{}, errors.Errorf("expected a boolean, got %v", v)
+		}
+		return protoreflect.ValueOfBool(b), nil
+	case protoreflect.Int32Kind:
+		n, err := strconv.ParseInt(numberString(v), 10, 32)
+		return protoreflect.ValueOfInt32(int32(n)), err
+	case protoreflect.Int64Kind:
+		n, err := strconv.ParseInt(numberString(v), 10, 64)
+		return protoreflect.ValueOfInt64(n), err
+	case protoreflect.FloatKind:
+		f, err := strconv.ParseFloat(numberString(v), 32)
+		return protoreflect.ValueOfFloat32(float32(f)), err
+	case protoreflect.DoubleKind:
+		f, err := strconv.ParseFloat(numberString(v), 64)
+		return protoreflect.ValueOfFloat64(f), err
+	case protoreflect.BytesKind:
+		s, _ := v.(string)
+		if !strings.HasPrefix(s, `\x`) {
+			return protoreflect.Value{}, errors.Errorf("expected a hex encoded bytea, got %v", v)
+		}
+		b, err := hex.DecodeString(s[2:])
+		return protoreflect.ValueOfBytes(b), err
+	case protoreflect.MessageKind:
+		t, err := parseTimestamp(v)
+		if err != nil {
+			return protoreflect.Value{}, err
+		}
+		return protoreflect.ValueOfMessage(timestamppb.New(t).ProtoReflect()), nil
+	default:
+		switch v := v.(type) {
+		case string:
+			return protoreflect.ValueOfString(v), nil
+		case json.Number:
+			return protoreflect.ValueOfString(v.String()), nil
+		default:
+			// Objects, such as json columns, are encoded as JSON.
+			b, err := json.Marshal(v)
+			return protoreflect.ValueOfString(string(b)), err
+		}
+	}
+}
+
+// numberString returns the text of a JSON number, or of a string holding one,
+// as numbers are encoded as strings by the lossless_numbers option.
+func numberString(v interface{}) string {
+	switch v := v.(type) {
+	case json.Number:
+		return v.String()
+	case string:
+		return v
+	default:
+		return ""
+	}
+}
+
+// parseTimestamp parses a timestamp as encoded by Postgres' JSON functions.
+// Timestamps without time zone are taken to be UTC.
+func parseTimestamp(v interface{}) (time.Time, error) {
+	s, _ := v.(string)
+	for _, layout := range []string{"2006-01-02T15:04:05.999999999Z07:00", "2006-01-02T15:04:05.999999999"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, errors.Errorf("expected a timestamp, got %v", v)
+}
+
+// qualifiedName returns the name of a table including its schema, if known.
+func qualifiedName(namespace, name string) string {
+	if namespace == "" {
+		return name
+	}
+
+	return namespace + "." + name
+}
+
+// identifier turns a table or column name into a valid protobuf identifier.
+func identifier(name string) string {
+	b := []byte(name)
+	for i, c := range b {
+		if !(c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')) {
+			b[i] = '_'
+		}
+	}
+	if len(b) == 0 || (b[0] >= '0' && b[0] <= '9') {
+		b = append([]byte{'_'}, b...)
+	}
+
+	return string(b)
+}
+
+func sameColumns(a, b *eventqueue.TableSchema) bool {
+	if a == b {
+		return true
+	}
+	if len(a.Columns) != len(b.Columns) {
+		return false
+	}
+	for i := range a.Columns {
+		if *a.Columns[i] != *b.Columns[i] {
+			return false
+		}
+	}
+
+	return true
+}
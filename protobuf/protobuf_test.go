@@ -0,0 +1,317 @@
+package protobuf
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/blendle/pg2kafka/eventqueue"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+var productsSchema = &eventqueue.TableSchema{Columns: []*eventqueue.Column{
+	{Name: "id", Type: "bigint", Position: 1},
+	{Name: "sku", Type: "text", Position: 2},
+	{Name: "price", Type: "numeric(10,2)", Position: 4},
+	{Name: "in stock", Type: "boolean", Position: 5},
+	{Name: "tags", Type: "text[]", Position: 6},
+	{Name: "image", Type: "bytea", Position: 7},
+	{Name: "updated_at", Type: "timestamp with time zone", Position: 8},
+	{Name: "weight", Type: "real", Position: 9},
+}}
+
+func TestEncoder_Encode(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pg2kafka")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // nolint: errcheck
+
+	path := filepath.Join(dir, "descriptors.pb")
+	e := NewEncoder(path)
+
+	event := &eventqueue.Event{
+		UUID:       "ea76e080-6acd-413a-96b3-131a42ab1002",
+		ExternalID: []byte("CM01-R"),
+		TableName:  "products",
+		Schema:     "public",
+		Statement:  "INSERT",
+		Data: []byte(`{
+			"id": 9007199254740993,
+			"sku": "CM01-R",
+			"price": 12.50,
+			"in stock": true,
+			"tags": ["mug", null, "red"],
+			"image": "\\x0102ff",
+			"updated_at": "2017-11-02T16:14:36.709116+01:00",
+			"weight": null
+		}`),
+		Source:    &eventqueue.Source{CurrentUser: "shop", SessionUser: "shop"},
+		TxID:      1337,
+		CreatedAt: time.Date(2017, 11, 2, 16, 14, 36, 709116000, time.UTC),
+	}
+
+	b, err := e.Encode(event, productsSchema)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Decode using only the descriptor set file, like a consumer would.
+	set := &descriptorpb.FileDescriptorSet{}
+	setBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = proto.Unmarshal(setBytes, set); err != nil {
+		t.Fatal(err)
+	}
+	files, err := protodesc.NewFiles(set)
+	if err != nil {
+		t.Fatalf("Invalid descriptor set: %v", err)
+	}
+
+	wrapper := decode(t, files, "pg2kafka.Event", b)
+	get := func(m protoreflect.Message, name string) protoreflect.Value {
+		return m.Get(m.Descriptor().Fields().ByName(protoreflect.Name(name)))
+	}
+
+	if get(wrapper, "uuid").String() != event.UUID || get(wrapper, "statement").String() != "INSERT" {
+		t.Errorf("Unexpected wrapper: %v", wrapper)
+	}
+	if get(wrapper, "txid").Int() != 1337 || get(wrapper, "external_id").String() != "CM01-R" {
+		t.Errorf("Unexpected wrapper: %v", wrapper)
+	}
+	if get(get(wrapper, "source").Message(), "current_user").String() != "shop" {
+		t.Errorf("Unexpected source: %v", get(wrapper, "source"))
+	}
+
+	data := get(wrapper, "data").Message()
+	typeURL := data.Get(data.Descriptor().Fields().ByName("type_url")).String()
+	if typeURL != "type.googleapis.com/pg2kafka.tables.public.products" {
+		t.Fatalf("Unexpected type url %q", typeURL)
+	}
+
+	value := data.Get(data.Descriptor().Fields().ByName("value")).Bytes()
+	row := decode(t, files, "pg2kafka.tables.public.products", value)
+	if get(row, "id").Int() != 9007199254740993 {
+		t.Errorf("Expected exact id, got %v", get(row, "id"))
+	}
+	if get(row, "price").String() != "12.50" {
+		t.Errorf("Expected price as string, got %v", get(row, "price"))
+	}
+	if !get(row, "in_stock").Bool() {
+		t.Errorf("Expected in_stock, got %v", get(row, "in_stock"))
+	}
+	if tags := get(row, "tags").List(); tags.Len() != 2 || tags.Get(1).String() != "red" {
+		t.Errorf("Unexpected tags: %v", tags)
+	}
+	if string(get(row, "image").Bytes()) != "\x01\x02\xff" {
+		t.Errorf("Unexpected image: %q", get(row, "image").Bytes())
+	}
+	if seconds := get(get(row, "updated_at").Message(), "seconds").Int(); seconds != 1509635676 {
+		t.Errorf("Unexpected updated_at seconds: %d", seconds)
+	}
+	if row.Has(row.Descriptor().Fields().ByName("weight")) {
+		t.Error("Expected null weight to be unset")
+	}
+	if number := row.Descriptor().Fields().ByName("price").Number(); number != 4 {
+		t.Errorf("Expected column position as field number, got %d", number)
+	}
+}
+
+func TestEncoder_Encode_SchemaChange(t *testing.T) {
+	e := NewEncoder("")
+	event := &eventqueue.Event{TableName: "products", Data: []byte(`{"id": 1}`)}
+
+	if _, err := e.Encode(event, productsSchema); err != nil {
+		t.Fatal(err)
+	}
+
+	event.Data = []byte(`{"id": 1, "color": "red"}`)
+	if _, err := e.Encode(event, productsSchema); err != nil {
+		t.Fatalf("Expected column missing from schema to be skipped, got %v", err)
+	}
+
+	altered := &eventqueue.TableSchema{Columns: append(
+		[]*eventqueue.Column{{Name: "color", Type: "text", Position: 10}},
+		productsSchema.Columns...,
+	)}
+	if _, err := e.Encode(event, altered); err != nil {
+		t.Fatalf("Expected message to be rebuilt for new column, got %v", err)
+	}
+
+	files, err := protodesc.NewFiles(e.DescriptorSet())
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := files.FindDescriptorByName("pg2kafka.tables.products")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.(protoreflect.MessageDescriptor).Fields().ByName("color") == nil {
+		t.Error("Expected descriptor set to contain the new column")
+	}
+}
+
+func TestEncoder_Encode_Schemas(t *testing.T) {
+	e := NewEncoder("")
+
+	audit := &eventqueue.TableSchema{Columns: []*eventqueue.Column{
+		{Name: "id", Type: "bigint", Position: 1},
+		{Name: "changed-by", Type: "text", Position: 2},
+		{Name: "changed_by", Type: "text", Position: 3},
+	}}
+	events := []*eventqueue.Event{
+		{TableName: "products", Schema: "public", Data: []byte(`{"id": 1}`)},
+		{
+			TableName: "products",
+			Schema:    "audit",
+			Data:      []byte(`{"id": 1, "changed-by": "a", "changed_by": "b"}`),
+		},
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := e.Encode(events[0], productsSchema); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := e.Encode(events[1], audit); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	public, audited := e.tables["public.products"], e.tables["audit.products"]
+	if public == nil || audited == nil || public.schema != productsSchema || audited.schema != audit {
+		t.Fatalf("Expected a message per schema, got %v", e.tables)
+	}
+	if public.message.FullName() != "pg2kafka.tables.public.products" {
+		t.Errorf("Unexpected message name %s", public.message.FullName())
+	}
+	if audited.message.FullName() != "pg2kafka.tables.audit.products" {
+		t.Errorf("Unexpected message name %s", audited.message.FullName())
+	}
+
+	if f := audited.fields["changed-by"]; f == nil || f.Name() != "changed_by" {
+		t.Errorf("Expected first column to keep its identifier, got %v", f)
+	}
+	if f := audited.fields["changed_by"]; f == nil || f.Name() != "changed_by_3" {
+		t.Errorf("Expected colliding column to get a unique identifier, got %v", f)
+	}
+}
+
+func TestEncoder_Encode_DroppedColumn(t *testing.T) {
+	e := NewEncoder("")
+
+	// The weight column was dropped and the type of in stock changed to
+	// integer while these events were in the queue.
+	altered := &eventqueue.TableSchema{Columns: []*eventqueue.Column{
+		{Name: "id", Type: "bigint", Position: 1},
+		{Name: "sku", Type: "text", Position: 2},
+		{Name: "in stock", Type: "integer", Position: 5},
+		{Name: "tags", Type: "bigint[]", Position: 6},
+	}}
+	backlog := []string{
+		`{"id": 1, "sku": "CM01-R", "in stock": true, "tags": ["mug"], "weight": 0.5}`,
+		`{"id": 1, "sku": "CM01-R", "in stock": 3, "tags": [1, 2]}`,
+	}
+
+	rows := []protoreflect.Message{}
+	for _, data := range backlog {
+		event := &eventqueue.Event{TableName: "products", Data: []byte(data)}
+		b, err := e.Encode(event, altered)
+		if err != nil {
+			t.Fatalf("Unexpected error encoding %s: %v", data, err)
+		}
+
+		files, err := protodesc.NewFiles(e.DescriptorSet())
+		if err != nil {
+			t.Fatal(err)
+		}
+		wrapper := decode(t, files, "pg2kafka.Event", b)
+		any := wrapper.Get(wrapper.Descriptor().Fields().ByName("data")).Message()
+		value := any.Get(any.Descriptor().Fields().ByName("value")).Bytes()
+		rows = append(rows, decode(t, files, "pg2kafka.tables.products", value))
+	}
+
+	field := func(m protoreflect.Message, name string) protoreflect.FieldDescriptor {
+		return m.Descriptor().Fields().ByName(protoreflect.Name(name))
+	}
+
+	if field(rows[0], "weight") != nil {
+		t.Error("Expected dropped column not to be in the message")
+	}
+	if rows[0].Get(field(rows[0], "sku")).String() != "CM01-R" {
+		t.Errorf("Expected sku to be set, got %v", rows[0])
+	}
+	if rows[0].Has(field(rows[0], "in_stock")) || rows[0].Has(field(rows[0], "tags")) {
+		t.Errorf("Expected values of the old type to be unset, got %v", rows[0])
+	}
+	inStock, tags := rows[1].Get(field(rows[1], "in_stock")), rows[1].Get(field(rows[1], "tags"))
+	if inStock.Int() != 3 || tags.List().Len() != 2 {
+		t.Errorf("Expected values of the new type to be set, got %v", rows[1])
+	}
+}
+
+func TestEncoder_Encode_WithoutSchema(t *testing.T) {
+	e := NewEncoder("")
+	event := &eventqueue.Event{TableName: "products", Data: []byte(`{"sku": "CM01-R", "id": 1}`)}
+
+	if _, err := e.Encode(event, nil); err != nil {
+		t.Fatal(err)
+	}
+	md := e.tables["products"].message
+	if md.Fields().ByName("id").Kind() != protoreflect.StringKind || md.Fields().ByName("sku") == nil {
+		t.Errorf("Expected columns to be encoded as strings, got %v", md.Fields())
+	}
+
+	if _, err := e.Encode(event, productsSchema); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.Encode(event, nil); err != nil {
+		t.Fatal(err)
+	}
+	if e.tables["products"].schema != productsSchema {
+		t.Error("Expected the message last built for the table to be used")
+	}
+}
+
+var identifierTests = []struct {
+	in  string
+	out string
+}{
+	{"users", "users"},
+	{"public.order_items", "public_order_items"},
+	{"in stock", "in_stock"},
+	{"2fa", "_2fa"},
+}
+
+func TestIdentifier(t *testing.T) {
+	for _, tt := range identifierTests {
+		t.Run(tt.in, func(t *testing.T) {
+			if actual := identifier(tt.in); actual != tt.out {
+				t.Errorf("identifier(%q) => %q, want: %q", tt.in, actual, tt.out)
+			}
+		})
+	}
+}
+
+func decode(t *testing.T, files *protoregistry.Files, name string, b []byte) protoreflect.Message {
+	t.Helper()
+
+	d, err := files.FindDescriptorByName(protoreflect.FullName(name))
+	if err != nil {
+		t.Fatalf("Message %s not found: %v", name, err)
+	}
+
+	msg := dynamicpb.NewMessage(d.(protoreflect.MessageDescriptor))
+	if err = proto.Unmarshal(b, msg); err != nil {
+		t.Fatalf("Error decoding %s: %v", name, err)
+	}
+
+	return msg
+}
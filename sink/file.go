@@ -0,0 +1,98 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// File is a Sink writing messages as JSON lines to a file or stdout. It is
+// mostly useful for debugging, and for piping events into other tools.
+type File struct {
+	mu      sync.Mutex
+	w       io.Writer
+	closer  io.Closer
+	encoder *json.Encoder
+}
+
+type fileLine struct {
+	Topic     string            `json:"topic"`
+	Key       *string           `json:"key"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	Value     interface{}       `json:"value"`
+	Timestamp time.Time         `json:"timestamp"`
+}
+
+// NewFile creates a new File sink writing to w.
+func NewFile(w io.Writer) *File {
+	return &File{w: w, encoder: json.NewEncoder(w)}
+}
+
+// NewStdout creates a new File sink writing to stdout.
+func NewStdout() *File {
+	return NewFile(os.Stdout)
+}
+
+// OpenFile creates a new File sink appending to the file at the given path.
+func OpenFile(path string) (*File, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, errors.Wrap(err, "error opening sink file")
+	}
+
+	s := NewFile(f)
+	s.closer = f
+	return s, nil
+}
+
+// Publish writes every message as a single line of JSON. Values that are
+// valid JSON are embedded as-is, other values are written as a string.
+func (f *File) Publish(ctx context.Context, msgs []*Message) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for i, msg := range msgs {
+		if err := ctx.Err(); err != nil {
+			return i, err
+		}
+
+		line := fileLine{
+			Topic:     msg.Topic,
+			Value:     string(msg.Value),
+			Timestamp: msg.Timestamp,
+		}
+		if json.Valid(msg.Value) {
+			line.Value = json.RawMessage(msg.Value)
+		}
+		if msg.Key != nil {
+			key := string(msg.Key)
+			line.Key = &key
+		}
+		if len(msg.Headers) > 0 {
+			line.Headers = map[string]string{}
+			for _, h := range msg.Headers {
+				line.Headers[h.Key] = string(h.Value)
+			}
+		}
+
+		if err := f.encoder.Encode(line); err != nil {
+			return i, errors.Wrap(err, "error writing message")
+		}
+	}
+
+	return len(msgs), nil
+}
+
+// Close closes the underlying file, if the sink opened it.
+func (f *File) Close() error {
+	if f.closer == nil {
+		return nil
+	}
+
+	return f.closer.Close()
+}
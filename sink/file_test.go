@@ -0,0 +1,44 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestFile_Publish(t *testing.T) {
+	buf := &bytes.Buffer{}
+	s := NewFile(buf)
+
+	msgs := []*Message{
+		{
+			Topic:     "pg2kafka.test.users",
+			Key:       []byte("jurre"),
+			Value:     []byte(`{"statement":"INSERT"}`),
+			Headers:   []Header{{Key: "pg2kafka.table", Value: []byte("users")}},
+			Timestamp: time.Date(2017, 11, 2, 16, 14, 36, 0, time.UTC),
+		},
+		{
+			Topic:     "pg2kafka.test.users",
+			Value:     []byte("not json"),
+			Timestamp: time.Date(2017, 11, 2, 16, 14, 36, 0, time.UTC),
+		},
+	}
+
+	n, err := s.Publish(context.Background(), msgs)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if n != 2 {
+		t.Fatalf("Expected 2 acknowledged messages, got %d", n)
+	}
+
+	expected := `{"topic":"pg2kafka.test.users","key":"jurre","headers":{"pg2kafka.table":"users"},"value":{"statement":"INSERT"},"timestamp":"2017-11-02T16:14:36Z"}` + "\n" + // nolint: lll
+		`{"topic":"pg2kafka.test.users","key":null,"value":"not json","timestamp":"2017-11-02T16:14:36Z"}` + "\n"
+
+	if buf.String() != expected {
+		t.Errorf("Unexpected output:\n%s\nwant:\n%s", buf.String(), expected)
+	}
+}
@@ -0,0 +1,91 @@
+package sink
+
+import (
+	"context"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/pkg/errors"
+)
+
+// Producer is the minimal required interface pg2kafka requires to produce
+// events to a kafka topic.
+type Producer interface {
+	Close()
+	Flush(int) int
+
+	Produce(*kafka.Message, chan kafka.Event) error
+	GetMetadata(*string, bool, int) (*kafka.Metadata, error)
+}
+
+// Kafka is a Sink producing messages to Kafka topics.
+type Kafka struct {
+	producer Producer
+}
+
+// NewKafka creates a new Kafka sink using the given producer.
+func NewKafka(p Producer) *Kafka {
+	return &Kafka{producer: p}
+}
+
+// Publish produces the messages one by one, waiting for each delivery report
+// before producing the next message so ordering is preserved.
+func (k *Kafka) Publish(ctx context.Context, msgs []*Message) (int, error) {
+	// The delivery report of a message that is no longer waited for, because
+	// the context was cancelled, must not block the producer.
+	deliveryChan := make(chan kafka.Event, 1)
+	for i, msg := range msgs {
+		err := k.producer.Produce(kafkaMessage(msg), deliveryChan)
+		if err != nil {
+			return i, errors.Wrap(err, "failed to produce")
+		}
+
+		var e kafka.Event
+		select {
+		case e = <-deliveryChan:
+		case <-ctx.Done():
+			return i, errors.Wrap(ctx.Err(), "stopped waiting for delivery report")
+		}
+		result, ok := e.(*kafka.Message)
+		if !ok {
+			return i, errors.Errorf("unexpected delivery report: %v", e)
+		}
+		if result.TopicPartition.Error != nil {
+			return i, errors.Wrap(result.TopicPartition.Error, "delivery failed")
+		}
+	}
+
+	return len(msgs), nil
+}
+
+// Ping checks whether the Kafka brokers are reachable by requesting cluster
+// metadata.
+func (k *Kafka) Ping() error {
+	_, err := k.producer.GetMetadata(nil, false, 5000)
+	return errors.Wrap(err, "error fetching kafka metadata")
+}
+
+// Close flushes outstanding messages and closes the producer.
+func (k *Kafka) Close() error {
+	k.producer.Flush(1000)
+	k.producer.Close()
+	return nil
+}
+
+func kafkaMessage(msg *Message) *kafka.Message {
+	topic := msg.Topic
+	message := &kafka.Message{
+		TopicPartition: kafka.TopicPartition{
+			Topic:     &topic,
+			Partition: kafka.PartitionAny, // nolint: gotype
+		},
+		Value:     msg.Value,
+		Key:       msg.Key,
+		Timestamp: msg.Timestamp,
+	}
+
+	for _, h := range msg.Headers {
+		message.Headers = append(message.Headers, kafka.Header{Key: h.Key, Value: h.Value})
+	}
+
+	return message
+}
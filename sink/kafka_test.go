@@ -0,0 +1,121 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/pkg/errors"
+)
+
+func TestKafka_Publish(t *testing.T) {
+	p := &mockProducer{}
+	s := NewKafka(p)
+
+	msgs := []*Message{
+		{
+			Topic:     "pg2kafka.test.users",
+			Key:       []byte("fefc72b4-d8df-4039-9fb9-bfcb18066a2b"),
+			Value:     []byte(`{"statement": "INSERT"}`),
+			Headers:   []Header{{Key: "pg2kafka.table", Value: []byte("users")}},
+			Timestamp: time.Unix(1509639276, 0),
+		},
+		{
+			Topic: "pg2kafka.test.users",
+			Value: []byte(`{"statement": "DELETE"}`),
+		},
+	}
+
+	n, err := s.Publish(context.Background(), msgs)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if n != 2 || len(p.messages) != 2 {
+		t.Fatalf("Expected 2 messages to be produced, got %d (%d acknowledged)", len(p.messages), n)
+	}
+
+	msg := p.messages[0]
+	if *msg.TopicPartition.Topic != "pg2kafka.test.users" {
+		t.Errorf("Expected topic 'pg2kafka.test.users', got %q", *msg.TopicPartition.Topic)
+	}
+
+	if !bytes.Equal(msg.Key, msgs[0].Key) {
+		t.Errorf("Expected key %q, got %q", msgs[0].Key, msg.Key)
+	}
+
+	if !msg.Timestamp.Equal(msgs[0].Timestamp) {
+		t.Errorf("Expected timestamp %v, got %v", msgs[0].Timestamp, msg.Timestamp)
+	}
+
+	if len(msg.Headers) != 1 || msg.Headers[0].Key != "pg2kafka.table" {
+		t.Errorf("Expected 'pg2kafka.table' header, got %v", msg.Headers)
+	}
+}
+
+func TestKafka_Publish_DeliveryFailure(t *testing.T) {
+	p := &mockProducer{failAfter: 1}
+	s := NewKafka(p)
+
+	msgs := []*Message{
+		{Topic: "pg2kafka.test.users", Value: []byte(`{}`)},
+		{Topic: "pg2kafka.test.users", Value: []byte(`{}`)},
+		{Topic: "pg2kafka.test.users", Value: []byte(`{}`)},
+	}
+
+	n, err := s.Publish(context.Background(), msgs)
+	if err == nil {
+		t.Fatal("Expected an error, got nil")
+	}
+
+	if n != 1 {
+		t.Errorf("Expected 1 acknowledged message, got %d", n)
+	}
+}
+
+func TestKafka_Publish_Cancelled(t *testing.T) {
+	s := NewKafka(&mockProducer{undelivered: true})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	n, err := s.Publish(ctx, []*Message{{Topic: "pg2kafka.test.users", Value: []byte(`{}`)}})
+	if errors.Cause(err) != context.Canceled {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+
+	if n != 0 {
+		t.Errorf("Expected 0 acknowledged messages, got %d", n)
+	}
+}
+
+type mockProducer struct {
+	messages    []*kafka.Message
+	failAfter   int
+	undelivered bool
+}
+
+func (p *mockProducer) Close() {
+}
+func (p *mockProducer) Flush(timeout int) int {
+	return 0
+}
+func (p *mockProducer) GetMetadata(topic *string, all bool, timeout int) (*kafka.Metadata, error) {
+	return &kafka.Metadata{}, nil
+}
+func (p *mockProducer) Produce(msg *kafka.Message, deliveryChan chan kafka.Event) error {
+	if p.failAfter > 0 && len(p.messages) >= p.failAfter {
+		msg.TopicPartition.Error = errors.New("broker unavailable")
+	}
+
+	p.messages = append(p.messages, msg)
+	if p.undelivered {
+		return nil
+	}
+	go func() {
+		deliveryChan <- msg
+	}()
+	return nil
+}
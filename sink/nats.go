@@ -0,0 +1,105 @@
+package sink
+
+import (
+	"context"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+)
+
+// KeyHeader is the header carrying the message key for sinks that have no
+// native notion of keys.
+const KeyHeader = "pg2kafka.key"
+
+// NATS is a Sink publishing messages to NATS JetStream. Messages are published
+// to a subject named after their topic, with their ID as `Nats-Msg-Id` so the
+// server discards duplicates when events are delivered more than once.
+//
+// A stream capturing the subjects, for example `pg2kafka.>`, needs to exist.
+type NATS struct {
+	conn       *nats.Conn
+	js         nats.JetStreamContext
+	ackTimeout time.Duration
+}
+
+// NewNATS creates a new NATS sink connected to the server at the given URL.
+func NewNATS(url string, opts ...nats.Option) (*NATS, error) {
+	if url == "" {
+		url = nats.DefaultURL
+	}
+
+	conn, err := nats.Connect(url, opts...)
+	if err != nil {
+		return nil, errors.Wrap(err, "error connecting to nats")
+	}
+
+	js, err := conn.JetStream()
+	if err != nil {
+		conn.Close()
+		return nil, errors.Wrap(err, "error setting up jetstream")
+	}
+
+	return &NATS{conn: conn, js: js, ackTimeout: 30 * time.Second}, nil
+}
+
+// Publish publishes all messages asynchronously, and waits until JetStream
+// acknowledged them.
+func (n *NATS) Publish(ctx context.Context, msgs []*Message) (int, error) {
+	futures := make([]nats.PubAckFuture, 0, len(msgs))
+	for _, msg := range msgs {
+		f, err := n.js.PublishMsgAsync(natsMessage(msg))
+		if err != nil {
+			acked, _ := n.waitForAcks(ctx, futures)
+			return acked, errors.Wrap(err, "failed to publish")
+		}
+		futures = append(futures, f)
+	}
+
+	return n.waitForAcks(ctx, futures)
+}
+
+// Ping checks whether the NATS server is reachable.
+func (n *NATS) Ping() error {
+	return errors.Wrap(n.conn.FlushTimeout(5*time.Second), "error pinging nats")
+}
+
+// Close drains the connection, so pending acknowledgements are received.
+func (n *NATS) Close() error {
+	return n.conn.Drain()
+}
+
+// waitForAcks waits for the futures in order, and returns how many of them
+// were acknowledged before the first failure.
+func (n *NATS) waitForAcks(ctx context.Context, futures []nats.PubAckFuture) (int, error) {
+	timeout := time.After(n.ackTimeout)
+	for i, f := range futures {
+		select {
+		case <-f.Ok():
+		case err := <-f.Err():
+			return i, errors.Wrap(err, "publish failed")
+		case <-timeout:
+			return i, errors.New("timed out waiting for publish acknowledgement")
+		case <-ctx.Done():
+			return i, errors.Wrap(ctx.Err(), "stopped waiting for publish acknowledgement")
+		}
+	}
+
+	return len(futures), nil
+}
+
+func natsMessage(msg *Message) *nats.Msg {
+	m := nats.NewMsg(msg.Topic)
+	m.Data = msg.Value
+	if msg.ID != "" {
+		m.Header.Set(nats.MsgIdHdr, msg.ID)
+	}
+	if msg.Key != nil {
+		m.Header.Set(KeyHeader, string(msg.Key))
+	}
+	for _, h := range msg.Headers {
+		m.Header.Set(h.Key, string(h.Value))
+	}
+
+	return m
+}
@@ -0,0 +1,116 @@
+package sink
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/nats-io/nats-server/v2/server"
+	natsserver "github.com/nats-io/nats-server/v2/test"
+	"github.com/nats-io/nats.go"
+)
+
+func TestNATS_Publish(t *testing.T) {
+	srv, cleanup := runJetStreamServer(t)
+	defer cleanup()
+
+	s, err := NewNATS(srv.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close() // nolint: errcheck
+
+	_, err = s.js.AddStream(&nats.StreamConfig{Name: "PG2KAFKA", Subjects: []string{"pg2kafka.>"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msgs := []*Message{
+		{
+			ID:    "ea76e080-6acd-413a-96b3-131a42ab1002",
+			Topic: "pg2kafka.test.users",
+			Key:   []byte("jurre"),
+			Value: []byte(`{"statement":"INSERT"}`),
+		},
+		{
+			ID:    "d6521ce5-4068-45e4-a9ad-c0949033a55b",
+			Topic: "pg2kafka.test.users",
+			Key:   []byte("jurre"),
+			Value: []byte(`{"statement":"UPDATE"}`),
+		},
+	}
+
+	n, err := s.Publish(context.Background(), msgs)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if n != 2 {
+		t.Fatalf("Expected 2 acknowledged messages, got %d", n)
+	}
+
+	// Publishing the same events again should be deduplicated by the server.
+	if _, err = s.Publish(context.Background(), msgs); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	info, err := s.js.StreamInfo("PG2KAFKA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.State.Msgs != 2 {
+		t.Errorf("Expected 2 messages in stream, got %d", info.State.Msgs)
+	}
+
+	m, err := s.js.GetMsg("PG2KAFKA", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Subject != "pg2kafka.test.users" {
+		t.Errorf("Expected subject 'pg2kafka.test.users', got %q", m.Subject)
+	}
+	if m.Header.Get(KeyHeader) != "jurre" {
+		t.Errorf("Expected key header 'jurre', got %q", m.Header.Get(KeyHeader))
+	}
+}
+
+func TestNATS_Publish_NoStream(t *testing.T) {
+	srv, cleanup := runJetStreamServer(t)
+	defer cleanup()
+
+	s, err := NewNATS(srv.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close() // nolint: errcheck
+
+	msgs := []*Message{{ID: "1", Topic: "pg2kafka.test.users", Value: []byte(`{}`)}}
+	n, err := s.Publish(context.Background(), msgs)
+	if err == nil {
+		t.Fatal("Expected an error, got nil")
+	}
+	if n != 0 {
+		t.Errorf("Expected no acknowledged messages, got %d", n)
+	}
+}
+
+func runJetStreamServer(t *testing.T) (*server.Server, func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "pg2kafka-nats")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := natsserver.DefaultTestOptions
+	opts.Port = -1
+	opts.JetStream = true
+	opts.StoreDir = dir
+
+	srv := natsserver.RunServer(&opts)
+	return srv, func() {
+		srv.Shutdown()
+		if err := os.RemoveAll(dir); err != nil {
+			t.Errorf("failed to remove %s: %v", dir, err)
+		}
+	}
+}
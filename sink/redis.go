@@ -0,0 +1,86 @@
+package sink
+
+import (
+	"context"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+)
+
+// Redis is a Sink appending messages to Redis Streams, using a stream per
+// topic. Every entry holds the event id, key, statement and value as fields,
+// followed by the message headers.
+type Redis struct {
+	client *redis.Client
+	maxLen int64
+}
+
+// NewRedis creates a new Redis sink. When maxLen is larger than zero, streams
+// are approximately trimmed to that many entries on every append.
+func NewRedis(client *redis.Client, maxLen int64) *Redis {
+	return &Redis{client: client, maxLen: maxLen}
+}
+
+// Publish appends all messages in a single MULTI/EXEC transaction, so a batch
+// is appended completely or not at all. Redis does not stop a transaction when
+// one of its commands fails, such as appending to a key that is not a stream,
+// so the entries appended by the other commands are deleted again.
+func (r *Redis) Publish(ctx context.Context, msgs []*Message) (int, error) {
+	if len(msgs) == 0 {
+		return 0, nil
+	}
+
+	pipe := r.client.TxPipeline()
+	cmds := make([]*redis.StringCmd, 0, len(msgs))
+	for _, msg := range msgs {
+		cmds = append(cmds, pipe.XAdd(ctx, r.xaddArgs(msg)))
+	}
+
+	_, err := pipe.Exec(ctx)
+	if err == nil {
+		return len(msgs), nil
+	}
+
+	appended := r.client.Pipeline()
+	for i, cmd := range cmds {
+		if cmd.Err() == nil {
+			appended.XDel(ctx, msgs[i].Topic, cmd.Val())
+		}
+	}
+	if appended.Len() > 0 {
+		if _, derr := appended.Exec(ctx); derr != nil {
+			return 0, errors.Wrap(derr, "failed to remove entries of failed batch")
+		}
+	}
+
+	return 0, errors.Wrap(err, "failed to append to stream")
+}
+
+// Ping checks whether the Redis server is reachable.
+func (r *Redis) Ping() error {
+	return errors.Wrap(r.client.Ping(context.Background()).Err(), "error pinging redis")
+}
+
+// Close closes the Redis client.
+func (r *Redis) Close() error {
+	return r.client.Close()
+}
+
+func (r *Redis) xaddArgs(msg *Message) *redis.XAddArgs {
+	values := []interface{}{
+		"id", msg.ID,
+		"key", string(msg.Key),
+		"statement", msg.Statement,
+		"value", msg.Value,
+	}
+	for _, h := range msg.Headers {
+		values = append(values, h.Key, h.Value)
+	}
+
+	return &redis.XAddArgs{
+		Stream: msg.Topic,
+		MaxLen: r.maxLen,
+		Approx: r.maxLen > 0,
+		Values: values,
+	}
+}
@@ -0,0 +1,134 @@
+package sink
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+func TestRedis_Publish(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mr.Close()
+
+	s := NewRedis(redis.NewClient(&redis.Options{Addr: mr.Addr()}), 0)
+	defer s.Close() // nolint: errcheck
+
+	n, err := s.Publish(context.Background(), []*Message{
+		{
+			ID:        "ea76e080-6acd-413a-96b3-131a42ab1002",
+			Statement: "INSERT",
+			Topic:     "pg2kafka.test.users",
+			Key:       []byte("jurre"),
+			Value:     []byte(`{"statement":"INSERT"}`),
+			Headers:   []Header{{Key: "pg2kafka.table", Value: []byte("users")}},
+		},
+		{
+			ID:        "d6521ce5-4068-45e4-a9ad-c0949033a55b",
+			Statement: "DELETE",
+			Topic:     "pg2kafka.test.products",
+			Value:     []byte(`{"statement":"DELETE"}`),
+		},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if n != 2 {
+		t.Fatalf("Expected 2 acknowledged messages, got %d", n)
+	}
+
+	entries, err := s.client.XRange(context.Background(), "pg2kafka.test.users", "-", "+").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("Expected 1 entry, got %d", len(entries))
+	}
+
+	expected := map[string]string{
+		"id":             "ea76e080-6acd-413a-96b3-131a42ab1002",
+		"key":            "jurre",
+		"statement":      "INSERT",
+		"value":          `{"statement":"INSERT"}`,
+		"pg2kafka.table": "users",
+	}
+	for k, v := range expected {
+		if entries[0].Values[k] != v {
+			t.Errorf("Expected field %q to be %q, got %v", k, v, entries[0].Values[k])
+		}
+	}
+
+	length, err := s.client.XLen(context.Background(), "pg2kafka.test.products").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if length != 1 {
+		t.Errorf("Expected 1 entry, got %d", length)
+	}
+}
+
+func TestRedis_Publish_MaxLen(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mr.Close()
+
+	s := NewRedis(redis.NewClient(&redis.Options{Addr: mr.Addr()}), 2)
+	defer s.Close() // nolint: errcheck
+
+	msgs := []*Message{}
+	for i := 0; i < 5; i++ {
+		msgs = append(msgs, &Message{Topic: "pg2kafka.test.users", Value: []byte(`{}`)})
+	}
+
+	if _, err = s.Publish(context.Background(), msgs); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	length, err := s.client.XLen(context.Background(), "pg2kafka.test.users").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if length > 2 {
+		t.Errorf("Expected stream to be trimmed to 2 entries, got %d", length)
+	}
+}
+
+func TestRedis_Publish_Error(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mr.Close()
+
+	s := NewRedis(redis.NewClient(&redis.Options{Addr: mr.Addr()}), 0)
+	defer s.Close() // nolint: errcheck
+
+	// Appending to a key holding another type fails.
+	if err = mr.Set("pg2kafka.test.products", "not a stream"); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := s.Publish(context.Background(), []*Message{
+		{Topic: "pg2kafka.test.users", Value: []byte(`{}`)},
+		{Topic: "pg2kafka.test.products", Value: []byte(`{}`)},
+		{Topic: "pg2kafka.test.users", Value: []byte(`{}`)},
+	})
+	if err == nil {
+		t.Fatal("Expected an error, got nil")
+	}
+	if n != 0 {
+		t.Errorf("Expected 0 acknowledged messages, got %d", n)
+	}
+
+	// The entries appended around the failed one are removed, so publishing
+	// the batch again does not append them twice.
+	if entries, _ := mr.Stream("pg2kafka.test.users"); len(entries) != 0 {
+		t.Errorf("Expected no entries in stream, got %d", len(entries))
+	}
+}
@@ -0,0 +1,50 @@
+// Package sink contains the destinations pg2kafka can deliver events to.
+package sink
+
+import (
+	"context"
+	"time"
+)
+
+// Sink delivers messages to a downstream system.
+type Sink interface {
+	// Publish delivers the given messages in order. It returns the number of
+	// leading messages that have been acknowledged by the downstream system,
+	// and an error if any of the remaining messages could not be delivered.
+	// Cancelling the context stops waiting for the downstream system, in
+	// which case messages reported as not delivered may still arrive.
+	Publish(ctx context.Context, msgs []*Message) (int, error)
+
+	// Close flushes any outstanding messages and releases the resources held
+	// by the sink.
+	Close() error
+}
+
+// Pinger is implemented by sinks that can check whether the downstream system
+// is reachable.
+type Pinger interface {
+	Ping() error
+}
+
+// Message is a single event, encoded and ready to be delivered to a sink.
+type Message struct {
+	// ID uniquely identifies the event, sinks supporting deduplication use it
+	// to discard messages that are delivered more than once.
+	ID string
+
+	// Table and Statement describe the change the message represents.
+	Table     string
+	Statement string
+
+	Topic     string
+	Key       []byte
+	Value     []byte
+	Headers   []Header
+	Timestamp time.Time
+}
+
+// Header is a key/value pair of metadata describing a message.
+type Header struct {
+	Key   string
+	Value []byte
+}
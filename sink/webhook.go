@@ -0,0 +1,167 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// SignatureHeader is the header holding the HMAC-SHA256 signature of a
+// webhook request body, formatted as `sha256=<hex digest>`.
+const SignatureHeader = "X-Pg2kafka-Signature"
+
+// WebhookConfig configures a Webhook sink.
+type WebhookConfig struct {
+	// URL receives events of tables not listed in TableURLs.
+	URL string
+
+	// TableURLs maps table names to the URL receiving their events.
+	TableURLs map[string]string
+
+	// Secret is used to sign request bodies, requests are not signed when it
+	// is empty.
+	Secret []byte
+
+	// MaxRetries is the number of times a request is retried after a network
+	// error or a 5xx response, waiting Backoff before the first retry and
+	// doubling the wait after every subsequent attempt. Requests are not
+	// retried when it is zero.
+	MaxRetries int
+	Backoff    time.Duration
+}
+
+// Webhook is a Sink POSTing batches of messages as a JSON array to HTTP
+// endpoints.
+type Webhook struct {
+	config WebhookConfig
+	client *http.Client
+}
+
+// webhookError is an error that should not be retried.
+type webhookError struct {
+	error
+}
+
+// NewWebhook creates a new Webhook sink.
+func NewWebhook(config WebhookConfig) *Webhook {
+	return &Webhook{
+		config: config,
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Publish posts consecutive messages for the same URL in a single request,
+// so ordering is preserved. A batch is acknowledged when the endpoint
+// responds with a 2xx status code.
+func (w *Webhook) Publish(ctx context.Context, msgs []*Message) (int, error) {
+	delivered := 0
+	for delivered < len(msgs) {
+		url := w.url(msgs[delivered].Table)
+		if url == "" {
+			return delivered, errors.Errorf("no webhook configured for table %q", msgs[delivered].Table)
+		}
+
+		end := delivered + 1
+		for end < len(msgs) && w.url(msgs[end].Table) == url {
+			end++
+		}
+
+		if err := w.post(ctx, url, msgs[delivered:end]); err != nil {
+			return delivered, err
+		}
+		delivered = end
+	}
+
+	return delivered, nil
+}
+
+// Close is a no-op, as the webhook sink holds no resources.
+func (w *Webhook) Close() error {
+	return nil
+}
+
+func (w *Webhook) url(table string) string {
+	if url, ok := w.config.TableURLs[table]; ok {
+		return url
+	}
+
+	return w.config.URL
+}
+
+func (w *Webhook) post(ctx context.Context, url string, msgs []*Message) error {
+	values := make([]json.RawMessage, 0, len(msgs))
+	for _, msg := range msgs {
+		values = append(values, json.RawMessage(msg.Value))
+	}
+
+	body, err := json.Marshal(values)
+	if err != nil {
+		return errors.Wrap(err, "error encoding webhook body")
+	}
+
+	backoff := w.config.Backoff
+	for attempt := 0; ; attempt++ {
+		err = w.send(ctx, url, body)
+		if _, permanent := err.(webhookError); err == nil || permanent {
+			return err
+		}
+		if attempt >= w.config.MaxRetries {
+			return errors.Wrapf(err, "webhook failed after %d attempts", attempt+1)
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return errors.Wrap(ctx.Err(), "webhook retry cancelled")
+		}
+		backoff *= 2
+	}
+}
+
+func (w *Webhook) send(ctx context.Context, url string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return webhookError{errors.Wrap(err, "error creating webhook request")}
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if len(w.config.Secret) > 0 {
+		req.Header.Set(SignatureHeader, Sign(w.config.Secret, body))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "error posting to webhook")
+	}
+	defer resp.Body.Close() // nolint: errcheck
+
+	// Drain the body so the connection can be reused.
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode <= 299:
+		return nil
+	case resp.StatusCode >= 500:
+		return errors.Errorf("webhook responded with %s", resp.Status)
+	default:
+		return webhookError{errors.Errorf("webhook responded with %s", resp.Status)}
+	}
+}
+
+// Sign returns the signature of a webhook body, as sent in the
+// SignatureHeader. Receivers should compute the same value using the shared
+// secret and compare the two using a constant time comparison.
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	_, _ = mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,175 @@
+package sink
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWebhook_Publish(t *testing.T) {
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("Expected content type 'application/json', got %q", r.Header.Get("Content-Type"))
+		}
+
+		body, _ = ioutil.ReadAll(r.Body)
+		if r.Header.Get(SignatureHeader) != Sign([]byte("secret"), body) {
+			t.Errorf("Invalid signature %q", r.Header.Get(SignatureHeader))
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	s := NewWebhook(WebhookConfig{URL: server.URL, Secret: []byte("secret")})
+	n, err := s.Publish(context.Background(), []*Message{
+		{Value: []byte(`{"statement":"INSERT"}`)},
+		{Value: []byte(`{"statement":"UPDATE"}`)},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if n != 2 {
+		t.Errorf("Expected 2 acknowledged messages, got %d", n)
+	}
+
+	expected := `[{"statement":"INSERT"},{"statement":"UPDATE"}]`
+	if string(body) != expected {
+		t.Errorf("Expected body %s, got %s", expected, body)
+	}
+}
+
+func TestWebhook_Publish_TableURLs(t *testing.T) {
+	mu := sync.Mutex{}
+	requests := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		mu.Lock()
+		requests = append(requests, r.URL.Path+" "+string(body))
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	s := NewWebhook(WebhookConfig{
+		URL:       server.URL + "/default",
+		TableURLs: map[string]string{"users": server.URL + "/users"},
+	})
+	n, err := s.Publish(context.Background(), []*Message{
+		{Table: "users", Value: []byte(`1`)},
+		{Table: "users", Value: []byte(`2`)},
+		{Table: "products", Value: []byte(`3`)},
+		{Table: "users", Value: []byte(`4`)},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if n != 4 {
+		t.Errorf("Expected 4 acknowledged messages, got %d", n)
+	}
+
+	expected := []string{"/users [1,2]", "/default [3]", "/users [4]"}
+	if len(requests) != len(expected) {
+		t.Fatalf("Expected requests %v, got %v", expected, requests)
+	}
+	for i := range expected {
+		if requests[i] != expected[i] {
+			t.Errorf("Expected request %q, got %q", expected[i], requests[i])
+		}
+	}
+}
+
+func TestWebhook_Publish_Retry(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	s := NewWebhook(WebhookConfig{URL: server.URL, MaxRetries: 3, Backoff: time.Millisecond})
+	n, err := s.Publish(context.Background(), []*Message{{Value: []byte(`{}`)}})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if n != 1 {
+		t.Errorf("Expected 1 acknowledged message, got %d", n)
+	}
+
+	if attempts != 3 {
+		t.Errorf("Expected 3 attempts, got %d", attempts)
+	}
+}
+
+func TestWebhook_Publish_Cancelled(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	s := NewWebhook(WebhookConfig{URL: server.URL, MaxRetries: 3, Backoff: time.Hour})
+	start := time.Now()
+	if _, err := s.Publish(ctx, []*Message{{Value: []byte(`{}`)}}); err == nil {
+		t.Fatal("Expected an error, got nil")
+	}
+
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("Expected retries to stop when the context is done, took %s", elapsed)
+	}
+}
+
+var webhookErrorTests = []struct {
+	status   int
+	attempts int
+}{
+	{http.StatusBadGateway, 3},
+	{http.StatusBadRequest, 1},
+}
+
+func TestWebhook_Publish_Error(t *testing.T) {
+	for _, tt := range webhookErrorTests {
+		t.Run(http.StatusText(tt.status), func(t *testing.T) {
+			attempts := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				attempts++
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+
+			s := NewWebhook(WebhookConfig{URL: server.URL, MaxRetries: 2, Backoff: time.Millisecond})
+			n, err := s.Publish(context.Background(), []*Message{{Value: []byte(`{}`)}})
+			if err == nil {
+				t.Fatal("Expected an error, got nil")
+			}
+
+			if n != 0 {
+				t.Errorf("Expected no acknowledged messages, got %d", n)
+			}
+
+			if attempts != tt.attempts {
+				t.Errorf("Expected %d attempts, got %d", tt.attempts, attempts)
+			}
+		})
+	}
+}
+
+func TestSign(t *testing.T) {
+	// echo -n 'hello' | openssl dgst -sha256 -hmac 'secret'
+	expected := "sha256=88aab3ede8d3adf94d26ab90d3bafd4a2083070c3bcce9c014ee04a443847c0b"
+	if actual := Sign([]byte("secret"), []byte("hello")); actual != expected {
+		t.Errorf("Expected %q, got %q", expected, actual)
+	}
+}
@@ -2,9 +2,12 @@ package sql_test
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
+	"fmt"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/blendle/pg2kafka/eventqueue"
 	"github.com/buger/jsonparser"
@@ -78,6 +81,14 @@ func TestSQL_Trigger_Insert(t *testing.T) {
 		t.Errorf("Expected 'users', got %s", events[0].TableName)
 	}
 
+	if events[0].Schema != "public" {
+		t.Errorf("Expected 'public', got %s", events[0].Schema)
+	}
+
+	if events[0].TxID == 0 {
+		t.Error("Expected event to have a transaction id")
+	}
+
 	email, _ := jsonparser.GetString(events[0].Data, "email")
 	if email != "jurre@blendle.com" {
 		t.Errorf("Expected 'jurre@blendle.com', got %s", email)
@@ -89,6 +100,64 @@ func TestSQL_Trigger_Insert(t *testing.T) {
 	}
 }
 
+func TestSQL_Trigger_Source(t *testing.T) {
+	db, eq, cleanup := setupTriggers(t)
+	defer cleanup()
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = tx.Exec(`
+	SET LOCAL application_name = 'pg2kafka-test';
+	SET LOCAL pg2kafka.actor = 'user-42';
+	INSERT INTO users (name, email) VALUES ('jurre', 'jurre@blendle.com');
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = db.Exec(`UPDATE users SET email = 'j@blendle.com' WHERE name = 'jurre'`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := eq.FetchUnprocessedRecords()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != 2 {
+		t.Fatalf("Expected 2 events, got %d", len(events))
+	}
+
+	source := events[0].Source
+	if source == nil {
+		t.Fatal("Expected event to have a source")
+	}
+
+	if source.CurrentUser == "" || source.SessionUser == "" {
+		t.Errorf("Expected current_user and session_user to be set, got %+v", source)
+	}
+
+	if source.ApplicationName != "pg2kafka-test" {
+		t.Errorf("Expected 'pg2kafka-test', got %q", source.ApplicationName)
+	}
+
+	if source.Actor != "user-42" {
+		t.Errorf("Expected 'user-42', got %q", source.Actor)
+	}
+
+	if events[1].Source == nil || events[1].Source.Actor != "" {
+		t.Errorf("Expected actor to be reset after transaction, got %+v", events[1].Source)
+	}
+}
+
 func TestSQL_Trigger_CreateWithNull(t *testing.T) {
 	db, eq, cleanup := setupTriggers(t)
 	defer cleanup()
@@ -180,6 +249,34 @@ func TestSQL_Trigger_UpdateExtensionColumn(t *testing.T) {
 	}
 }
 
+func TestSQL_Trigger_Numeric(t *testing.T) {
+	db, eq, cleanup := setupTriggers(t)
+	defer cleanup()
+
+	_, err := db.Exec(`
+	DROP TABLE IF EXISTS orders;
+	CREATE TABLE orders (id bigint, total numeric(19,2));
+	SELECT pg2kafka.setup('orders', 'id');
+	INSERT INTO orders (id, total) VALUES (9007199254740993, 19999999999999999.99);
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := eq.FetchUnprocessedRecords()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != 1 {
+		t.Fatalf("Expected 1 event, got %d", len(events))
+	}
+
+	if string(events[0].Data) != `{"id": 9007199254740993, "total": 19999999999999999.99}` {
+		t.Errorf("Expected numbers to be exact, got %s", events[0].Data)
+	}
+}
+
 func TestSQL_Snapshot(t *testing.T) {
 	db, eq, cleanup := setupTriggers(t)
 	defer cleanup()
@@ -222,6 +319,364 @@ func TestSQL_Snapshot(t *testing.T) {
 	}
 }
 
+func TestSQL_FetchUnprocessedRecords_Cancelled(t *testing.T) {
+	_, eq, cleanup := setupTriggers(t)
+	defer cleanup()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := eq.FetchUnprocessedRecordsContext(ctx); err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
+
+func TestSQL_FetchUnprocessedRecordsAfter(t *testing.T) {
+	db, eq, cleanup := setupTriggers(t)
+	defer cleanup()
+
+	_, err := db.Exec(`
+	INSERT INTO users (name, email) VALUES ('jurre', 'jurre@blendle.com');
+	INSERT INTO users (name, email) VALUES ('sjoerd', 'sjoerd@blendle.com');
+	INSERT INTO users (name, email) VALUES ('erik', 'erik@blendle.com');
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	eq.SetBatchSize(2, 0)
+	sizes := []int{}
+	for id := 0; ; {
+		events, ferr := eq.FetchUnprocessedRecordsAfter(id)
+		if ferr != nil {
+			t.Fatal(ferr)
+		}
+		if len(events) == 0 {
+			break
+		}
+		sizes = append(sizes, len(events))
+		id = events[len(events)-1].ID
+	}
+	if fmt.Sprint(sizes) != "[2 1]" {
+		t.Errorf("Expected batches of 2 and 1 events, got %v", sizes)
+	}
+
+	// The first event is fetched even though it exceeds the limit.
+	eq.SetBatchSize(10, 1)
+	events, err := eq.FetchUnprocessedRecords()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 {
+		t.Errorf("Expected byte limit to fetch 1 event, got %d", len(events))
+	}
+}
+
+func TestSQL_TeardownTable(t *testing.T) {
+	db, eq, cleanup := setupTriggers(t)
+	defer cleanup()
+
+	if err := eq.TeardownTable(context.Background(), "users"); err != nil {
+		t.Fatal(err)
+	}
+
+	triggerName := ""
+	err := db.QueryRow(selectTriggerNamesQuery).Scan(&triggerName)
+	if err != sql.ErrNoRows {
+		t.Fatalf("Expected no triggers, got %q (%v)", triggerName, err)
+	}
+
+	err = eq.SnapshotTable(context.Background(), "users")
+	if err == nil || err.Error() != "table users is not set up" {
+		t.Errorf("Expected snapshot to fail for table that is not set up, got %v", err)
+	}
+
+	if err = eq.SetupTable(context.Background(), "users", "email"); err != nil {
+		t.Fatalf("Expected table to be set up again, got %v", err)
+	}
+}
+
+func TestSQL_TableStatuses(t *testing.T) {
+	db, eq, cleanup := setupTriggers(t)
+	defer cleanup()
+
+	_, err := db.Exec(`
+	INSERT INTO users (name, email) VALUES ('jurre', 'jurre@blendle.com');
+	DROP TRIGGER users_enqueue_event ON users;
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = eq.SnapshotTable(context.Background(), "users"); err != nil {
+		t.Fatal(err)
+	}
+
+	statuses, err := eq.TableStatuses(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(statuses) != 1 {
+		t.Fatalf("Expected 1 status, got %d", len(statuses))
+	}
+
+	s := statuses[0]
+	if s.Table != "users" || s.ExternalID != "uuid" || !s.Tracked {
+		t.Errorf("Unexpected status: %+v", s)
+	}
+
+	if s.Trigger {
+		t.Error("Expected trigger to be missing")
+	}
+
+	if s.Backlog.Count != 2 {
+		t.Errorf("Expected 2 unprocessed events, got %d", s.Backlog.Count)
+	}
+}
+
+func TestSQL_Replay(t *testing.T) {
+	db, eq, cleanup := setupTriggers(t)
+	defer cleanup()
+
+	_, err := db.Exec(`
+	INSERT INTO users (name, email) VALUES ('jurre', 'jurre@blendle.com');
+	INSERT INTO users (name, email) VALUES ('sjoerd', 'sjoerd@blendle.com');
+	INSERT INTO users (name, email) VALUES ('erik', 'erik@blendle.com');
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := eq.FetchUnprocessedRecords()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, event := range events[:2] {
+		if err = eq.MarkEventAsProcessed(event.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var replayed []*eventqueue.Event
+	opts := eventqueue.ReplayOptions{Table: "users", BatchSize: 1, Rate: 1000}
+	count, err := eq.Replay(context.Background(), opts, func(events []*eventqueue.Event) error {
+		replayed = append(replayed, events...)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count != 2 || len(replayed) != 2 {
+		t.Fatalf("Expected 2 processed events to be replayed, got %d", count)
+	}
+	if replayed[0].ID != events[0].ID || replayed[1].ID != events[1].ID {
+		t.Errorf("Expected events %d and %d, got %d and %d", events[0].ID, events[1].ID, replayed[0].ID, replayed[1].ID)
+	}
+
+	opts = eventqueue.ReplayOptions{Table: "users", FromID: events[1].ID, ToID: events[2].ID}
+	count, err = eq.Replay(context.Background(), opts, func([]*eventqueue.Event) error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("Expected 1 event in id range, got %d", count)
+	}
+
+	opts = eventqueue.ReplayOptions{Table: "users", Until: events[0].CreatedAt.Add(-24 * time.Hour)}
+	count, err = eq.Replay(context.Background(), opts, func([]*eventqueue.Event) error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("Expected no events before the first, got %d", count)
+	}
+
+	unprocessed, err := eq.FetchUnprocessedRecords()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unprocessed) != 1 {
+		t.Errorf("Expected replay to leave 1 unprocessed event, got %d", len(unprocessed))
+	}
+}
+
+func TestSQL_Migrate(t *testing.T) {
+	db, eq, cleanup := setupTriggers(t)
+	defer cleanup()
+
+	migrations, err := eventqueue.Migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	version := 0
+	err = db.QueryRow(`SELECT max(version) FROM pg2kafka.schema_migrations`).Scan(&version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != migrations[len(migrations)-1].Version {
+		t.Errorf("Expected schema version %d, got %d", migrations[len(migrations)-1].Version, version)
+	}
+
+	// Concurrent migrators wait for each other, and apply nothing twice.
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			applied, merr := eq.Migrate(context.Background())
+			if merr == nil && len(applied) != 0 {
+				merr = fmt.Errorf("expected no migrations to be applied again, got %d", len(applied))
+			}
+			errs <- merr
+		}()
+	}
+	for i := 0; i < 2; i++ {
+		if err = <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestSQL_Migrate_Upgrade(t *testing.T) {
+	db, eq, cleanup := setupTriggers(t)
+	defer cleanup()
+
+	// Installs from before migrations were versioned have the schema, but no
+	// schema_migrations table.
+	if _, err := db.Exec(`DROP TABLE pg2kafka.schema_migrations`); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := eq.Migrate(context.Background()); err != nil {
+		t.Fatalf("Expected existing install to be upgraded, got %v", err)
+	}
+
+	triggerName := ""
+	if err := db.QueryRow(selectTriggerNamesQuery).Scan(&triggerName); err != nil {
+		t.Fatalf("Expected existing triggers to be kept, got %v", err)
+	}
+}
+
+func TestSQL_Verify(t *testing.T) {
+	db, eq, cleanup := setupTriggers(t)
+	defer cleanup()
+
+	drift, err := eq.Verify(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(drift) != 0 {
+		t.Fatalf("Expected no drift, got %v", drift)
+	}
+
+	_, err = db.Exec(`
+	DROP TRIGGER users_enqueue_event ON users;
+	ALTER TABLE users RENAME COLUMN uuid TO id;
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	drift, err = eq.Verify(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(drift) != 2 {
+		t.Fatalf("Expected 2 problems, got %v", drift)
+	}
+	if drift[0].Problem != eventqueue.DriftMissingTrigger || drift[1].Problem != eventqueue.DriftMissingColumn {
+		t.Errorf("Expected missing trigger and column, got %v", drift)
+	}
+
+	repaired, err := eq.Repair(context.Background(), drift)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(repaired) != 1 || repaired[0].Problem != eventqueue.DriftMissingTrigger {
+		t.Errorf("Expected the missing trigger to be repaired, got %v", repaired)
+	}
+
+	triggerName := ""
+	if err = db.QueryRow(selectTriggerNamesQuery).Scan(&triggerName); err != nil {
+		t.Fatalf("Expected trigger to be reinstalled, got %v", err)
+	}
+}
+
+func TestSQL_SchemaEvents(t *testing.T) {
+	db, eq, cleanup := setupTriggers(t)
+	defer cleanup()
+
+	if err := eq.EnableSchemaEvents(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := db.Exec(`
+	CREATE TABLE untracked (id integer);
+	ALTER TABLE untracked ADD COLUMN name text;
+	DROP TABLE untracked;
+	ALTER TABLE users ADD COLUMN age integer NOT NULL DEFAULT 0;
+	INSERT INTO users (name, email) VALUES ('jurre', 'jurre@blendle.com');
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := eq.FetchUnprocessedRecords()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != 2 {
+		t.Fatalf("Expected a SCHEMA and an INSERT event, got %d events", len(events))
+	}
+
+	if events[0].Statement != eventqueue.StatementSchema || events[1].Statement != "INSERT" {
+		t.Fatalf("Expected SCHEMA before INSERT, got %s and %s", events[0].Statement, events[1].Statement)
+	}
+
+	column, err := jsonparser.GetString(events[0].Data, "columns", "[5]", "name")
+	if err != nil || column != "age" {
+		t.Errorf("Expected column 'age' to be added, got %q (%v): %s", column, err, events[0].Data)
+	}
+
+	nullable, err := jsonparser.GetBoolean(events[0].Data, "columns", "[5]", "nullable")
+	if err != nil || nullable {
+		t.Errorf("Expected column 'age' not to be nullable, got %s", events[0].Data)
+	}
+}
+
+func TestSQL_TableSchema(t *testing.T) {
+	_, eq, cleanup := setupTriggers(t)
+	defer cleanup()
+
+	schema, err := eq.TableSchema(context.Background(), "users")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []eventqueue.Column{
+		{Name: "uuid", Type: "uuid", Nullable: false, Position: 1},
+		{Name: "name", Type: "character varying", Nullable: true, Position: 2},
+		{Name: "email", Type: "text", Nullable: true, Position: 3},
+		{Name: "properties", Type: "hstore", Nullable: true, Position: 4},
+		{Name: "data", Type: "jsonb", Nullable: true, Position: 5},
+	}
+
+	if len(schema.Columns) != len(expected) {
+		t.Fatalf("Expected %d columns, got %d", len(expected), len(schema.Columns))
+	}
+	for i, c := range schema.Columns {
+		if *c != expected[i] {
+			t.Errorf("Expected column %+v, got %+v", expected[i], *c)
+		}
+	}
+
+	if _, err = eq.TableSchema(context.Background(), "missing"); err == nil {
+		t.Error("Expected error for missing table")
+	}
+}
+
 func setupTriggers(t *testing.T) (*sql.DB, *eventqueue.Queue, func()) {
 	t.Helper()
 	db, err := sql.Open("postgres", os.Getenv("DATABASE_URL"))
@@ -231,7 +686,7 @@ func setupTriggers(t *testing.T) (*sql.DB, *eventqueue.Queue, func()) {
 
 	eq := eventqueue.NewWithDB(db)
 
-	err = eq.ConfigureOutboundEventQueueAndTriggers("./")
+	_, err = eq.Migrate(context.Background())
 	if err != nil {
 		t.Fatal(err)
 	}